@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/drone/envsubst/v2"
+)
+
+// renderer renders template files using the lookup function.
+type renderer struct {
+	lookup    func(string) (string, bool)
+	parseOpts []envsubst.Option
+	execOpts  envsubst.ExecuteOptions
+	stdin     io.Reader // standard input, if no input file is given
+	stdout    io.Writer // standard output, if no output file is given
+}
+
+// render parses and executes the named template file.
+func (r *renderer) render(path string) ([]byte, error) {
+	tmpl, err := envsubst.ParseFile(path, r.parseOpts...)
+	if err != nil {
+		return nil, err
+	}
+	out, err := tmpl.ExecuteWith(r.lookup, r.execOpts)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(out), nil
+}
+
+// renderFiles renders the named template files and writes the
+// concatenated output to the named output file, or to standard
+// output if the name is empty. A single input file keeps its
+// file mode.
+func (r *renderer) renderFiles(paths []string, output string) error {
+	var buf bytes.Buffer
+	for _, path := range paths {
+		out, err := r.render(path)
+		if err != nil {
+			return err
+		}
+		buf.Write(out)
+	}
+	if output == "" {
+		_, err := r.stdout.Write(buf.Bytes())
+		return err
+	}
+	mode := os.FileMode(0644)
+	if len(paths) == 1 {
+		info, err := os.Stat(paths[0])
+		if err != nil {
+			return err
+		}
+		mode = info.Mode().Perm()
+	}
+	return writeFile(output, buf.Bytes(), mode)
+}
+
+// renderInPlace renders the named template file, or every file
+// in the named directory tree, and replaces the file contents
+// with the output. If the named file is a symbolic link, the
+// file it links to is rendered. Symbolic links in the directory
+// tree are left unchanged.
+func (r *renderer) renderInPlace(path string) error {
+	path, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return err
+	}
+	return filepath.Walk(path, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		out, err := r.render(path)
+		if err != nil {
+			return err
+		}
+		return writeFile(path, out, info.Mode().Perm())
+	})
+}
+
+// renderDir renders every file in the input directory tree to the
+// same relative path in the output directory tree, keeping file
+// and directory modes. Symbolic links are copied unchanged.
+func (r *renderer) renderDir(input, output string) error {
+	// directories are created writable, and their modes are set
+	// once their contents are written, deepest first, so that
+	// read-only directories can be rendered.
+	var dirs []string
+	var modes []os.FileMode
+	input, err := filepath.EvalSymlinks(input)
+	if err != nil {
+		return err
+	}
+	err = filepath.Walk(input, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(input, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(output, rel)
+		switch {
+		case info.IsDir():
+			dirs = append(dirs, target)
+			modes = append(modes, info.Mode().Perm())
+			if err := os.MkdirAll(target, 0700); err != nil {
+				return err
+			}
+			return os.Chmod(target, 0700)
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if err := os.Remove(target); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			return os.Symlink(link, target)
+		}
+		out, err := r.render(path)
+		if err != nil {
+			return err
+		}
+		return writeFile(target, out, info.Mode().Perm())
+	})
+	if err != nil {
+		return err
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := os.Chmod(dirs[i], modes[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFile writes data to the named file with the given mode. The
+// data is written to a temporary file that is renamed on success,
+// so that the file is never partially written.
+func writeFile(path string, data []byte, mode os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(mode); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// isDir reports whether the named file is a directory.
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
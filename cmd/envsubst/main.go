@@ -1,28 +1,217 @@
 package main
 
 import (
-	"bufio"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"os"
+	"strings"
 
 	"github.com/drone/envsubst/v2"
+	"github.com/drone/envsubst/v2/parse"
 )
 
+const usage = `Usage: envsubst [OPTION] [SHELL-FORMAT]
+
+Substitutes the values of environment variables.
+
+  -i, --input FILE     read the template from FILE instead of standard
+                       input. May be repeated, and FILE may be a directory
+  -o, --output FILE    write the output to FILE instead of standard output
+      --in-place       write the output back to each input file
+      --env-file FILE  read variables from the dotenv FILE. May be repeated
+      --env-override   prefer variables from dotenv files over the process
+                       environment
+      --escape FORMAT  escape substituted values for FORMAT, one of json,
+                       yaml, shell, xml or url
+  -v, --variables      output the variables occurring in SHELL-FORMAT
+
+If a SHELL-FORMAT is given, only those environment variables that are
+referenced in SHELL-FORMAT are substituted; references to all other
+variables are left unchanged. In this mode unbraced references such as
+$VAR are also substituted, and $$ is left unchanged. Otherwise, all
+${VAR} references are substituted, and $$ is replaced with $.
+
+Variables in the process environment take precedence over variables
+read from dotenv files, unless --env-override is given.
+
+If the input is a directory, every file in the directory tree is
+rendered to the same relative path in the output directory, keeping
+file modes. Symbolic links are copied unchanged, and are not rendered
+in place.
+`
+
+// escapers maps the --escape formats to escapers.
+var escapers = map[string]envsubst.Escaper{
+	"json":  envsubst.JSONString,
+	"yaml":  envsubst.YAMLScalar,
+	"shell": envsubst.ShellQuote,
+	"xml":   envsubst.XMLText,
+	"url":   envsubst.URLQuery,
+}
+
+// stringSlice is a flag value that may be repeated.
+type stringSlice []string
+
+func (s *stringSlice) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringSlice) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
 func main() {
-	stdin := bufio.NewScanner(os.Stdin)
-	stdout := bufio.NewWriter(os.Stdout)
+	err := run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr, os.LookupEnv)
+	if err == flag.ErrHelp {
+		return
+	}
+	if perr, ok := err.(*parse.Error); ok {
+		fmt.Fprintln(os.Stderr, perr)
+		os.Exit(1)
+	}
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+}
 
-	for stdin.Scan() {
-		line, err := envsubst.EvalEnv(stdin.Text())
+// run runs the command with the arguments, reading the template from
+// stdin and writing the output to stdout unless files are given, and
+// resolving variables with the environ lookup function. Usage is
+// written to stderr.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer, environ envsubst.Resolver) error {
+	var (
+		variables bool
+		inPlace   bool
+		inputs    stringSlice
+		output    string
+		envFiles  stringSlice
+		override  bool
+		escape    string
+	)
+	flags := flag.NewFlagSet("envsubst", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	flags.BoolVar(&variables, "v", false, "")
+	flags.BoolVar(&variables, "variables", false, "")
+	flags.BoolVar(&inPlace, "in-place", false, "")
+	flags.Var(&inputs, "i", "")
+	flags.Var(&inputs, "input", "")
+	flags.StringVar(&output, "o", "", "")
+	flags.StringVar(&output, "output", "", "")
+	flags.Var(&envFiles, "env-file", "")
+	flags.BoolVar(&override, "env-override", false, "")
+	flags.StringVar(&escape, "escape", "", "")
+	flags.Usage = func() {
+		fmt.Fprint(stderr, usage)
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() > 1 {
+		return errors.New("too many arguments")
+	}
+
+	var names []string
+	if flags.NArg() == 1 {
+		format, err := envsubst.Parse(flags.Arg(0), envsubst.WithBareVariables())
 		if err != nil {
-			log.Fatalf("Error while envsubst: %v", err)
+			return fmt.Errorf("while parsing SHELL-FORMAT: %v", err)
+		}
+		for _, v := range format.Variables() {
+			names = append(names, v.Name)
 		}
-		_, err = fmt.Fprintln(stdout, line)
+	}
+
+	if variables {
+		if flags.NArg() == 0 {
+			return errors.New("missing SHELL-FORMAT argument")
+		}
+		for _, name := range names {
+			fmt.Fprintln(stdout, name)
+		}
+		return nil
+	}
+
+	r := &renderer{lookup: environ, stdin: stdin, stdout: stdout}
+	if len(envFiles) != 0 {
+		env, err := envsubst.LoadDotenv(envFiles...)
 		if err != nil {
-			log.Fatalf("Error while writing to stdout: %v", err)
+			return fmt.Errorf("while reading env file: %v", err)
+		}
+		r.lookup = envsubst.Chain(environ, env.Lookup)
+		if override {
+			r.lookup = envsubst.Chain(env.Lookup, environ)
+		}
+	}
+	if escape != "" {
+		e, ok := escapers[escape]
+		if !ok {
+			return fmt.Errorf("unknown --escape format %s", escape)
+		}
+		r.parseOpts = append(r.parseOpts, envsubst.WithEscaper(e))
+	}
+	if flags.NArg() == 1 {
+		allowed := map[string]bool{}
+		for _, name := range names {
+			allowed[name] = true
 		}
-		stdout.Flush()
+		r.parseOpts = append(r.parseOpts, envsubst.WithBareVariables(), envsubst.WithLiteralDollars())
+		r.execOpts.Preserve = func(name string) bool {
+			return !allowed[name]
+		}
+	}
+
+	switch {
+	case inPlace && output != "":
+		return errors.New("--in-place cannot be used with --output")
+	case inPlace && len(inputs) == 0:
+		return errors.New("--in-place requires an --input file")
+	case inPlace:
+		for _, path := range inputs {
+			if err := r.renderInPlace(path); err != nil {
+				return err
+			}
+		}
+		return nil
+	case len(inputs) == 0:
+		return r.renderStdin(output)
+	case len(inputs) == 1 && isDir(inputs[0]):
+		if output == "" {
+			return fmt.Errorf("--output directory required for input directory %s", inputs[0])
+		}
+		return r.renderDir(inputs[0], output)
+	default:
+		return r.renderFiles(inputs, output)
 	}
 }
 
+// renderStdin renders the template read from standard input to the
+// named output file, or streams it to standard output if the name is
+// empty.
+func (r *renderer) renderStdin(output string) error {
+	// the builder returns its buffer as the string without a copy.
+	var in strings.Builder
+	if _, err := io.Copy(&in, r.stdin); err != nil {
+		return err
+	}
+	tmpl, err := envsubst.Parse(in.String(), r.parseOpts...)
+	if perr, ok := err.(*parse.Error); ok {
+		perr.Filename = "<stdin>"
+	}
+	if err != nil {
+		return err
+	}
+	if output == "" {
+		return tmpl.ExecuteToWith(r.stdout, r.lookup, r.execOpts)
+	}
+	out, err := tmpl.ExecuteWith(r.lookup, r.execOpts)
+	if err != nil {
+		return err
+	}
+	return writeFile(output, []byte(out), 0644)
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/drone/envsubst/v2"
+)
+
+var environ = envsubst.Map(map[string]string{
+	"NAME": "web",
+	"PORT": "8080",
+})
+
+// tempDir creates a temporary directory with the files, and returns
+// the directory and a function that removes it.
+func tempDir(t *testing.T, files map[string]string) (string, func()) {
+	dir, err := ioutil.TempDir("", "envsubst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		// set the mode regardless of the umask.
+		os.Chmod(path, 0644)
+	}
+	return dir, func() {
+		// read-only directories are made writable to be removed.
+		filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err == nil && info.IsDir() {
+				os.Chmod(path, 0755)
+			}
+			return nil
+		})
+		os.RemoveAll(dir)
+	}
+}
+
+// readFile returns the contents and the permissions of the file.
+func readFile(t *testing.T, path string) (string, os.FileMode) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b), info.Mode().Perm()
+}
+
+func TestRunStdin(t *testing.T) {
+	var tests = []struct {
+		args   []string
+		input  string
+		output string
+	}{
+		{nil, "${NAME}:${PORT} $NAME\n", "web:8080 $NAME\n"},
+		{[]string{"$PORT"}, "$NAME ${NAME}:$PORT ${PORT}\n", "$NAME ${NAME}:8080 8080\n"},
+		{[]string{"--", "${NAME} $PORT"}, "$NAME\n${PORT}\n", "web\n8080\n"},
+		// $$ is replaced with $, unless a SHELL-FORMAT is given.
+		{nil, "$${NAME} $$x\n", "${NAME} $x\n"},
+		{[]string{"$PORT"}, "$${PORT} $$x $$$PORT\n", "$8080 $$x $$8080\n"},
+		{[]string{"--escape", "json"}, `{"a": "${NAME}"}`, `{"a": "web"}`},
+		{[]string{"--escape", "shell"}, "echo ${HOST:-a b}", "echo 'a b'"},
+		// the input is not read line by line.
+		{nil, "${NAME}", "web"},
+		{nil, "${HOST:-a\nb}:${PORT}\n", "a\nb:8080\n"},
+		{nil, strings.Repeat("x", 1<<16) + "${NAME}\n", strings.Repeat("x", 1<<16) + "web\n"},
+	}
+	for _, test := range tests {
+		var stdout bytes.Buffer
+		err := run(test.args, strings.NewReader(test.input), &stdout, nil, environ)
+		if err != nil {
+			t.Errorf("Want %q with args %q rendered without error, got %v", test.input, test.args, err)
+			continue
+		}
+		if got := stdout.String(); got != test.output {
+			t.Errorf("Want %q with args %q rendered as %q, got %q", test.input, test.args, test.output, got)
+		}
+	}
+}
+
+func TestRunVariables(t *testing.T) {
+	var stdout bytes.Buffer
+	if err := run([]string{"-v", "$PORT ${NAME:-x}"}, nil, &stdout, nil, environ); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stdout.String(), "PORT\nNAME\n"; got != want {
+		t.Errorf("Want variables %q, got %q", want, got)
+	}
+}
+
+func TestRunFiles(t *testing.T) {
+	dir, cleanup := tempDir(t, map[string]string{
+		"a.tpl": "name: ${NAME}\n",
+		"b.tpl": "port: ${PORT}\n",
+	})
+	defer cleanup()
+	a, b := filepath.Join(dir, "a.tpl"), filepath.Join(dir, "b.tpl")
+	os.Chmod(a, 0600)
+
+	// several inputs are concatenated.
+	var stdout bytes.Buffer
+	if err := run([]string{"-i", a, "--input", b}, nil, &stdout, nil, environ); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := stdout.String(), "name: web\nport: 8080\n"; got != want {
+		t.Errorf("Want output %q, got %q", want, got)
+	}
+
+	out := filepath.Join(dir, "out.yaml")
+	if err := run([]string{"-i", a, "-i", b, "-o", out}, nil, nil, nil, environ); err != nil {
+		t.Fatal(err)
+	}
+	if got, mode := readFile(t, out); got != "name: web\nport: 8080\n" || mode != 0644 {
+		t.Errorf("Want concatenated output with mode 0644, got %q with mode %v", got, mode)
+	}
+
+	// a single input keeps its file mode.
+	if err := run([]string{"-i", a, "-o", out}, nil, nil, nil, environ); err != nil {
+		t.Fatal(err)
+	}
+	if got, mode := readFile(t, out); got != "name: web\n" || mode != 0600 {
+		t.Errorf("Want output with mode 0600, got %q with mode %v", got, mode)
+	}
+}
+
+func TestRunInPlace(t *testing.T) {
+	dir, cleanup := tempDir(t, map[string]string{
+		"a.conf":       "name ${NAME};\n",
+		"conf.d/b.sh":  "port=${PORT}\n",
+		"conf.d/c.txt": "$$NAME\n",
+		"d.tpl":        "${NAME}\n",
+	})
+	defer cleanup()
+	os.Chmod(filepath.Join(dir, "conf.d/b.sh"), 0755)
+	os.Chmod(filepath.Join(dir, "d.tpl"), 0600)
+	// links in the tree are left unchanged, and a named link is
+	// replaced by rendering the file it links to.
+	os.Symlink("../d.tpl", filepath.Join(dir, "conf.d/d.link"))
+	os.Symlink("d.tpl", filepath.Join(dir, "d.link"))
+
+	err := run([]string{"--in-place", "-i", filepath.Join(dir, "a.conf"), "-i", filepath.Join(dir, "conf.d"), "-i", filepath.Join(dir, "d.link")}, nil, nil, nil, environ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		name string
+		data string
+		mode os.FileMode
+	}{
+		{"a.conf", "name web;\n", 0644},
+		{"conf.d/b.sh", "port=8080\n", 0755},
+		{"conf.d/c.txt", "$NAME\n", 0644},
+		{"d.tpl", "web\n", 0600},
+	}
+	for _, test := range tests {
+		if got, mode := readFile(t, filepath.Join(dir, test.name)); got != test.data || mode != test.mode {
+			t.Errorf("Want %s rewritten as %q with mode %v, got %q with mode %v", test.name, test.data, test.mode, got, mode)
+		}
+	}
+	for _, name := range []string{"d.link", "conf.d/d.link"} {
+		info, err := os.Lstat(filepath.Join(dir, name))
+		if err != nil {
+			t.Error(err)
+		} else if info.Mode()&os.ModeSymlink == 0 {
+			t.Errorf("Want %s left a symbolic link, got mode %v", name, info.Mode())
+		}
+	}
+
+	// temporary files are removed.
+	files, _ := ioutil.ReadDir(filepath.Join(dir, "conf.d"))
+	if len(files) != 3 {
+		t.Errorf("Want 3 files in conf.d, got %d", len(files))
+	}
+}
+
+func TestRunDir(t *testing.T) {
+	dir, cleanup := tempDir(t, map[string]string{
+		"in/a.conf":       "name ${NAME};\n",
+		"in/conf.d/b.sh":  "port=${PORT}\n",
+		"in/conf.d/c.txt": "text\n",
+		"in/ro/d.txt":     "${PORT}\n",
+	})
+	defer cleanup()
+	os.Chmod(filepath.Join(dir, "in/conf.d/b.sh"), 0750)
+	os.Chmod(filepath.Join(dir, "in/conf.d"), 0700)
+	os.Chmod(filepath.Join(dir, "in/ro"), 0555)
+	os.Symlink("a.conf", filepath.Join(dir, "in/a.link"))
+
+	// rendering again replaces the output.
+	in, out := filepath.Join(dir, "in"), filepath.Join(dir, "out")
+	for i := 0; i < 2; i++ {
+		if err := run([]string{"-i", in, "-o", out}, nil, nil, nil, environ); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var tests = []struct {
+		name string
+		data string
+		mode os.FileMode
+	}{
+		{"a.conf", "name web;\n", 0644},
+		{"conf.d/b.sh", "port=8080\n", 0750},
+		{"conf.d/c.txt", "text\n", 0644},
+		{"ro/d.txt", "8080\n", 0644},
+	}
+	for _, test := range tests {
+		if got, mode := readFile(t, filepath.Join(out, test.name)); got != test.data || mode != test.mode {
+			t.Errorf("Want %s rendered as %q with mode %v, got %q with mode %v", test.name, test.data, test.mode, got, mode)
+		}
+	}
+	for name, want := range map[string]os.FileMode{"conf.d": 0700, "ro": 0555} {
+		info, err := os.Stat(filepath.Join(out, name))
+		if err != nil {
+			t.Error(err)
+		} else if info.Mode().Perm() != want {
+			t.Errorf("Want output directory %s with mode %v, got %v", name, want, info.Mode().Perm())
+		}
+	}
+	if link, err := os.Readlink(filepath.Join(out, "a.link")); err != nil || link != "a.conf" {
+		t.Errorf("Want a.link copied as a link to a.conf, got %q, %v", link, err)
+	}
+	// the input is unchanged.
+	if got, _ := readFile(t, filepath.Join(in, "a.conf")); got != "name ${NAME};\n" {
+		t.Errorf("Want input unchanged, got %q", got)
+	}
+}
+
+func TestRunEnvFile(t *testing.T) {
+	dir, cleanup := tempDir(t, map[string]string{
+		"a.env": "NAME=api\nDEBUG=true\nURL=http://${NAME}:${PORT}\n",
+		"b.env": "DEBUG=false\n",
+	})
+	defer cleanup()
+	a, b := filepath.Join(dir, "a.env"), filepath.Join(dir, "b.env")
+
+	var tests = []struct {
+		args   []string
+		output string
+	}{
+		// the environment takes precedence over env files.
+		{[]string{"--env-file", a}, "web true http://api:"},
+		// later env files take precedence over earlier files.
+		{[]string{"--env-file", a, "--env-file", b}, "web false http://api:"},
+		// env files take precedence with --env-override.
+		{[]string{"--env-file", a, "--env-override"}, "api true http://api:"},
+	}
+	for _, test := range tests {
+		var stdout bytes.Buffer
+		err := run(test.args, strings.NewReader("${NAME} ${DEBUG} ${URL}"), &stdout, nil, environ)
+		if err != nil {
+			t.Errorf("Want args %q rendered without error, got %v", test.args, err)
+			continue
+		}
+		if got := stdout.String(); got != test.output {
+			t.Errorf("Want args %q rendered as %q, got %q", test.args, test.output, got)
+		}
+	}
+}
+
+func TestRunError(t *testing.T) {
+	dir, cleanup := tempDir(t, map[string]string{
+		"in/a.tpl": "${NAME}",
+	})
+	defer cleanup()
+	in := filepath.Join(dir, "in")
+
+	var tests = []struct {
+		args  []string
+		input string
+		err   string
+	}{
+		{[]string{"$A", "$B"}, "", "too many arguments"},
+		{[]string{"-v"}, "", "missing SHELL-FORMAT argument"},
+		{[]string{"--bogus"}, "", "flag provided but not defined: -bogus"},
+		{nil, "${NAME}\n${NAME\n", "<stdin>:2:1: "},
+		{nil, "${HOST:-a\n${NAME}", "<stdin>:1:1: "},
+		{[]string{"--in-place", "-i", in, "-o", "out"}, "", "--in-place cannot be used with --output"},
+		{[]string{"--in-place"}, "", "--in-place requires an --input file"},
+		{[]string{"-i", in}, "", "--output directory required for input directory " + in},
+		{[]string{"--env-file", filepath.Join(dir, "missing.env")}, "", "while reading env file: "},
+		{[]string{"--escape", "csv"}, "", "unknown --escape format csv"},
+	}
+	for _, test := range tests {
+		var stderr bytes.Buffer
+		err := run(test.args, strings.NewReader(test.input), &bytes.Buffer{}, &stderr, environ)
+		if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+			t.Errorf("Want args %q to fail with %q, got %v", test.args, test.err, err)
+		}
+	}
+}
+
+func TestRunUsage(t *testing.T) {
+	var stderr bytes.Buffer
+	if err := run([]string{"-h"}, nil, nil, &stderr, environ); err != flag.ErrHelp {
+		t.Errorf("Want flag.ErrHelp, got %v", err)
+	}
+	if got := stderr.String(); got != usage {
+		t.Errorf("Want usage written to stderr, got %q", got)
+	}
+}
@@ -0,0 +1,206 @@
+package envsubst
+
+import (
+	"container/list"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/drone/envsubst/v2/parse"
+	"github.com/drone/envsubst/v2/pattern"
+)
+
+// cacheSize is the maximum number of parsed templates cached by
+// Compile.
+const cacheSize = 1024
+
+// maxCacheText is the maximum length of the template text cached by
+// Compile. Longer texts are parsed each time, so that the cache does
+// not hold on to large inputs.
+const maxCacheText = 4 << 10
+
+// cache is the cache of parsed templates used by Compile.
+var cache = newLRU(cacheSize)
+
+// Compile is like Parse, but caches the parsed template, so that
+// compiling the same template text with the same options does not
+// parse the text again. The cache holds the most recently used
+// templates, and texts longer than 4 KiB are not cached. Compile is
+// safe for concurrent use by multiple goroutines.
+func Compile(s string, opts ...Option) (*Template, error) {
+	t := newTemplate(opts)
+	if len(s) > maxCacheText {
+		if err := t.parse(s); err != nil {
+			return nil, err
+		}
+		return t, nil
+	}
+	key := cacheKey{
+		text:     s,
+		mode:     t.mode,
+		patterns: t.patterns,
+		funcs:    funcNames(t.funcs),
+	}
+	if e, ok := cache.get(key); ok {
+		t.text, t.tree, t.static = s, e.tree, e.static
+		return t, nil
+	}
+	if err := t.parse(s); err != nil {
+		return nil, err
+	}
+	cache.add(key, t.tree, t.static)
+	return t, nil
+}
+
+// MustCompile is like Compile but panics if the template cannot be
+// parsed.
+func MustCompile(s string, opts ...Option) *Template {
+	t, err := Compile(s, opts...)
+	if err != nil {
+		panic(`envsubst: Compile(` + s + `): ` + err.Error())
+	}
+	return t
+}
+
+// funcNames returns the sorted names of the functions, which
+// determine the pipe functions accepted by the parser.
+func funcNames(funcs map[string]Func) string {
+	if len(funcs) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(funcs))
+	for name := range funcs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, "|")
+}
+
+// static holds the function arguments of a template that contain no
+// substitutions, compiled when the template is parsed.
+type static struct {
+	patterns map[*parse.TextNode]*pattern.Pattern // trim and replace patterns
+	exprs    map[*parse.TextNode]parse.Expr       // substring offsets and lengths
+}
+
+// compileStatic compiles the function arguments of the tree that
+// contain no substitutions.
+func compileStatic(tree *parse.Tree, mode pattern.Mode) *static {
+	st := &static{
+		patterns: map[*parse.TextNode]*pattern.Pattern{},
+		exprs:    map[*parse.TextNode]parse.Expr{},
+	}
+	parse.Inspect(tree.Root, func(node parse.Node) bool {
+		switch n := node.(type) {
+		case *parse.TrimNode:
+			st.addPattern(n.Pattern, mode)
+		case *parse.ReplaceNode:
+			st.addPattern(n.Pattern, mode)
+		case *parse.SubstrNode:
+			st.addExpr(n.Offset)
+			st.addExpr(n.Length)
+		}
+		return true
+	})
+	return st
+}
+
+func (st *static) addPattern(node parse.Node, mode pattern.Mode) {
+	if text, ok := node.(*parse.TextNode); ok && text.Value != "" {
+		if p, err := pattern.CompileMode(text.Value, mode); err == nil {
+			st.patterns[text] = p
+		}
+	}
+}
+
+func (st *static) addExpr(node parse.Node) {
+	if text, ok := node.(*parse.TextNode); ok {
+		if x, err := parse.ParseArith(text.Value); err == nil {
+			st.exprs[text] = x
+		}
+	}
+}
+
+// pattern returns the compiled pattern of the argument, or nil if
+// the argument contains substitutions.
+func (st *static) pattern(node parse.Node) *pattern.Pattern {
+	text, ok := node.(*parse.TextNode)
+	if !ok {
+		return nil
+	}
+	return st.patterns[text]
+}
+
+// expr returns the parsed arithmetic expression of the argument, or
+// nil if the argument contains substitutions.
+func (st *static) expr(node parse.Node) parse.Expr {
+	text, ok := node.(*parse.TextNode)
+	if !ok {
+		return nil
+	}
+	return st.exprs[text]
+}
+
+// cacheKey identifies a parsed template. The parse tree and the
+// compiled arguments depend on the text, the parse mode, the pattern
+// mode and the names of the pipe functions.
+type cacheKey struct {
+	text     string
+	mode     parse.Mode
+	patterns pattern.Mode
+	funcs    string
+}
+
+// cacheEntry is a parsed template in the cache.
+type cacheEntry struct {
+	key    cacheKey
+	tree   *parse.Tree
+	static *static
+}
+
+// lru is a least recently used cache of parsed templates. It is safe
+// for concurrent use by multiple goroutines.
+type lru struct {
+	mu    sync.Mutex
+	size  int
+	list  *list.List // most recently used first
+	items map[cacheKey]*list.Element
+}
+
+func newLRU(size int) *lru {
+	return &lru{
+		size:  size,
+		list:  list.New(),
+		items: map[cacheKey]*list.Element{},
+	}
+}
+
+// get returns the cached entry for the key, and reports whether the
+// entry is cached.
+func (c *lru) get(key cacheKey) (*cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.list.MoveToFront(el)
+	return el.Value.(*cacheEntry), true
+}
+
+// add adds the entry for the key, evicting the least recently used
+// entry if the cache is full.
+func (c *lru) add(key cacheKey, tree *parse.Tree, st *static) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.list.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.list.PushFront(&cacheEntry{key: key, tree: tree, static: st})
+	if c.list.Len() > c.size {
+		el := c.list.Back()
+		c.list.Remove(el)
+		delete(c.items, el.Value.(*cacheEntry).key)
+	}
+}
@@ -0,0 +1,104 @@
+package envsubst
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/drone/envsubst/v2/parse"
+)
+
+func TestCompile(t *testing.T) {
+	a, err := Compile("${v##*/}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := MustCompile("${v##*/}")
+	if a.tree != b.tree {
+		t.Errorf("Want compiled templates to share the parse tree")
+	}
+	if got, _ := b.Execute(func(string) string { return "/usr/bin" }); got != "bin" {
+		t.Errorf("Want cached template expanded to %q, got %q", "bin", got)
+	}
+
+	// changes to the tree returned by Tree do not affect the cached
+	// templates.
+	a.Tree().Root.(*parse.TrimNode).Name = "w"
+	if got := MustCompile("${v##*/}").tree.Root.(*parse.TrimNode).Name; got != "v" {
+		t.Errorf("Want cached tree unchanged, got name %q", got)
+	}
+
+	// templates parsed with other options are cached separately.
+	c := MustCompile("${v##*/}", WithExtGlob())
+	if c.tree == a.tree {
+		t.Errorf("Want templates compiled with other options cached separately")
+	}
+
+	// long texts are not cached.
+	long := strings.Repeat("x", maxCacheText) + "${v}"
+	if MustCompile(long).tree == MustCompile(long).tree {
+		t.Errorf("Want texts longer than %d bytes not cached", maxCacheText)
+	}
+
+	// templates share the tree, but not the functions.
+	upper := MustCompile("${v|f}", Funcs(map[string]Func{"f": strings.ToUpper}))
+	lower := MustCompile("${v|f}", Funcs(map[string]Func{"f": strings.ToLower}))
+	mapping := func(string) string { return "Mixed" }
+	if got, _ := upper.Execute(mapping); got != "MIXED" {
+		t.Errorf("Want %q, got %q", "MIXED", got)
+	}
+	if got, _ := lower.Execute(mapping); got != "mixed" {
+		t.Errorf("Want %q, got %q", "mixed", got)
+	}
+
+	if _, err := Compile("${v|f}"); err == nil {
+		t.Errorf("Want unknown function error for template compiled without functions")
+	}
+}
+
+func TestMustCompilePanic(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Want MustCompile to panic")
+		}
+	}()
+	MustCompile("${")
+}
+
+func TestLRU(t *testing.T) {
+	c := newLRU(2)
+	key := func(s string) cacheKey { return cacheKey{text: s} }
+	c.add(key("a"), &parse.Tree{}, nil)
+	c.add(key("b"), &parse.Tree{}, nil)
+	c.get(key("a"))
+	c.add(key("c"), &parse.Tree{}, nil)
+
+	if _, ok := c.get(key("b")); ok {
+		t.Errorf("Want least recently used entry evicted")
+	}
+	for _, s := range []string{"a", "c"} {
+		if _, ok := c.get(key(s)); !ok {
+			t.Errorf("Want entry %q cached", s)
+		}
+	}
+}
+
+func TestCompileConcurrent(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				s := "${v:" + strconv.Itoa(j%10) + ":1}"
+				got, err := Eval(s, func(string) string { return "0123456789" })
+				if err != nil || got != strconv.Itoa(j%10) {
+					t.Errorf("Want %q expanded to %d, got %q, %v", s, j%10, got, err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,230 @@
+package envsubst
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/drone/envsubst/v2/parse"
+)
+
+// Dotenv is a set of variables loaded from dotenv files.
+type Dotenv map[string]string
+
+// ReadDotenv reads variables in dotenv format from r.
+//
+// Each line has the form KEY=value, optionally prefixed by export.
+// Blank lines and lines starting with # are ignored. Unquoted values
+// end at the end of the line or at a # preceded by whitespace. Values
+// enclosed in single quotes are taken literally and values enclosed
+// in double quotes support the \n, \r, \t, \" and \\ escapes. Quoted
+// values may span multiple lines.
+//
+// Unquoted and double quoted values may reference previously defined
+// variables using ${var} syntax.
+func ReadDotenv(r io.Reader) (Dotenv, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	d := Dotenv{}
+	return d, d.parse(string(b))
+}
+
+// LoadDotenv reads variables in dotenv format from the named files.
+// Variables in later files override variables in earlier files, and
+// may reference variables defined in earlier files.
+func LoadDotenv(paths ...string) (Dotenv, error) {
+	d := Dotenv{}
+	for _, path := range paths {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := d.parse(string(b)); err != nil {
+			return nil, fmt.Errorf("%s:%v", path, err)
+		}
+	}
+	return d, nil
+}
+
+// Get returns the value of the named variable, or the empty string
+// if the variable is not set. It can be used as a mapping function.
+func (d Dotenv) Get(name string) string {
+	return d[name]
+}
+
+// Lookup returns the value of the named variable and reports whether
+// the variable is set. It can be used as a lookup function.
+func (d Dotenv) Lookup(name string) (string, bool) {
+	v, ok := d[name]
+	return v, ok
+}
+
+// parse parses the dotenv formatted source and adds the variables.
+func (d Dotenv) parse(src string) error {
+	// lineOf returns the line number of the rest of the source. The
+	// source is only consumed, so newlines are counted once, from the
+	// previously counted offset.
+	orig := src
+	lines, counted := 1, 0
+	lineOf := func(rest string) int {
+		offset := len(orig) - len(rest)
+		lines += strings.Count(orig[counted:offset], "\n")
+		counted = offset
+		return lines
+	}
+	for {
+		src = strings.TrimLeft(src, " \t\r\n")
+		if src == "" {
+			return nil
+		}
+		if src[0] == '#' {
+			src = skipLine(src)
+			continue
+		}
+
+		line := lineOf(src)
+		if strings.HasPrefix(src, "export ") || strings.HasPrefix(src, "export\t") {
+			src = strings.TrimLeft(src[len("export"):], " \t")
+		}
+
+		i := strings.IndexAny(src, "= \t\r\n")
+		if i == -1 {
+			i = len(src)
+		}
+		if i == 0 || !isDotenvKey(src[:i]) {
+			return fmt.Errorf("%d: invalid variable name", line)
+		}
+		key := src[:i]
+		src = strings.TrimLeft(src[i:], " \t")
+		if src == "" || src[0] != '=' {
+			return fmt.Errorf("%d: missing = after %s", line, key)
+		}
+		src = strings.TrimLeft(src[1:], " \t")
+
+		var value string
+		var expand bool
+		var err error
+		switch {
+		case strings.HasPrefix(src, "'"):
+			value, src, err = scanSingleQuoted(src[1:])
+		case strings.HasPrefix(src, `"`):
+			value, src, err = scanDoubleQuoted(src[1:])
+			expand = true
+		default:
+			value, src = scanUnquoted(src)
+			expand = true
+		}
+		if err != nil {
+			return fmt.Errorf("%d: %v", line, err)
+		}
+
+		// only whitespace or a comment may follow the value.
+		src = strings.TrimLeft(src, " \t\r")
+		if src != "" && src[0] != '\n' && src[0] != '#' {
+			return fmt.Errorf("%d: unexpected characters after value of %s", lineOf(src), key)
+		}
+		src = skipLine(src)
+
+		if expand {
+			// values are parsed rather than compiled, so that they
+			// are not kept in the template cache.
+			var t *Template
+			t, err = Parse(value)
+			if err == nil {
+				value, err = t.ExecuteLookup(d.Lookup)
+			}
+			if perr, ok := err.(*parse.Error); ok {
+				err = perr.Err
+			}
+			if err != nil {
+				return fmt.Errorf("%d: %v", line, err)
+			}
+		}
+		d[key] = value
+	}
+}
+
+// skipLine returns the source starting at the next newline, or the
+// empty string if there is no newline.
+func skipLine(src string) string {
+	if i := strings.IndexByte(src, '\n'); i != -1 {
+		return src[i:]
+	}
+	return ""
+}
+
+// scanUnquoted returns the unquoted value at the start of src and
+// the remaining source. Trailing whitespace and comments are removed.
+func scanUnquoted(src string) (value, rest string) {
+	i := strings.IndexByte(src, '\n')
+	if i == -1 {
+		i = len(src)
+	}
+	value, rest = src[:i], src[i:]
+	for j := 1; j < len(value); j++ {
+		if value[j] == '#' && (value[j-1] == ' ' || value[j-1] == '\t') {
+			value = value[:j]
+			break
+		}
+	}
+	return strings.TrimRight(value, " \t\r"), rest
+}
+
+// scanSingleQuoted returns the literal value preceding the closing
+// single quote and the source following the closing quote.
+func scanSingleQuoted(src string) (value, rest string, err error) {
+	i := strings.IndexByte(src, '\'')
+	if i == -1 {
+		return "", "", fmt.Errorf("unterminated quoted value")
+	}
+	return src[:i], src[i+1:], nil
+}
+
+// scanDoubleQuoted returns the unescaped value preceding the closing
+// double quote and the source following the closing quote.
+func scanDoubleQuoted(src string) (value, rest string, err error) {
+	var b strings.Builder
+	for i := 0; i < len(src); i++ {
+		switch c := src[i]; c {
+		case '"':
+			return b.String(), src[i+1:], nil
+		case '\\':
+			if i+1 == len(src) {
+				break
+			}
+			i++
+			switch src[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case '"', '\\':
+				b.WriteByte(src[i])
+			default:
+				b.WriteByte('\\')
+				b.WriteByte(src[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", "", fmt.Errorf("unterminated quoted value")
+}
+
+// isDotenvKey reports whether s is a valid variable name.
+func isDotenvKey(s string) bool {
+	for i, r := range s {
+		switch {
+		case r == '_', r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		case i > 0 && (r >= '0' && r <= '9' || r == '.' || r == '-'):
+		default:
+			return false
+		}
+	}
+	return true
+}
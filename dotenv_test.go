@@ -0,0 +1,100 @@
+package envsubst
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadDotenv(t *testing.T) {
+	src := `
+# comment
+HOST=example.com
+export PORT = 8080
+URL=http://${HOST}:${PORT}/ # trailing comment
+EMPTY=
+HASH=a#b
+SINGLE='${HOST} # not a comment'
+DOUBLE="line1\nline2 \"${PORT}\""
+MULTI="first
+second"
+LITERAL='a
+b'
+	INDENTED=yes
+`
+	got, err := ReadDotenv(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Dotenv{
+		"HOST":     "example.com",
+		"PORT":     "8080",
+		"URL":      "http://example.com:8080/",
+		"EMPTY":    "",
+		"HASH":     "a#b",
+		"SINGLE":   "${HOST} # not a comment",
+		"DOUBLE":   "line1\nline2 \"8080\"",
+		"MULTI":    "first\nsecond",
+		"LITERAL":  "a\nb",
+		"INDENTED": "yes",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Want dotenv %q, got %q", want, got)
+	}
+	if v, ok := got.Lookup("EMPTY"); !ok || v != "" {
+		t.Errorf("Want EMPTY set to the empty string")
+	}
+}
+
+func TestReadDotenvError(t *testing.T) {
+	var tests = []struct {
+		src string
+		err string
+	}{
+		{"A=1\n1A=2", "2: invalid variable name"},
+		{"A=1\nB", "2: missing = after B"},
+		{"A=1\n\nB='abc", "3: unterminated quoted value"},
+		{"A=\"abc\ndef", "1: unterminated quoted value"},
+		{"A='abc' def", "1: unexpected characters after value of A"},
+		{"A=${B", "1: missing closing brace"},
+		{"A=\"x\ny\" z", "2: unexpected characters after value of A"},
+		{"# A\nA=\"x\ny\"\n\nB", "5: missing = after B"},
+	}
+	for _, test := range tests {
+		_, err := ReadDotenv(strings.NewReader(test.src))
+		if err == nil || err.Error() != test.err {
+			t.Errorf("Want error %q for %q, got %v", test.err, test.src, err)
+		}
+	}
+}
+
+func TestLoadDotenv(t *testing.T) {
+	dir, err := ioutil.TempDir("", "envsubst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	base := filepath.Join(dir, "base.env")
+	prod := filepath.Join(dir, "prod.env")
+	ioutil.WriteFile(base, []byte("HOST=localhost\nNAME=app\n"), 0644)
+	ioutil.WriteFile(prod, []byte("HOST=${NAME}.example.com\n"), 0644)
+
+	got, err := LoadDotenv(base, prod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Dotenv{"HOST": "app.example.com", "NAME": "app"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Want dotenv %q, got %q", want, got)
+	}
+
+	ioutil.WriteFile(prod, []byte("HOST=${NAME\n"), 0644)
+	_, err = LoadDotenv(base, prod)
+	if err == nil || err.Error() != prod+":1: missing closing brace" {
+		t.Errorf("Want error with file name and line, got %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+package envsubst
+
+import (
+	"encoding/json"
+	"html"
+	"net/url"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Escaper escapes a substituted value for the output format of the
+// template, such as a JSON string or a YAML scalar.
+type Escaper func(string) string
+
+// JSONString escapes the value for use inside a double quoted JSON
+// string. The surrounding quotes are not included.
+func JSONString(s string) string {
+	var b strings.Builder
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	out := b.String()
+	// trim the quotes and the trailing newline.
+	return out[1 : len(out)-2]
+}
+
+// YAMLScalar escapes the value for use as a YAML scalar. Values that
+// are safe as plain scalars and are read as strings are returned
+// unchanged, and all other values, including values such as true,
+// null and 007 that would be read as another type, are returned as
+// double quoted scalars.
+func YAMLScalar(s string) string {
+	if isPlainYAML(s) {
+		return s
+	}
+	return `"` + JSONString(s) + `"`
+}
+
+// ShellQuote quotes the value as a single POSIX shell word, using
+// single quotes.
+func ShellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// XMLText escapes the value for use in XML or HTML text and in quoted
+// attribute values.
+func XMLText(s string) string {
+	return html.EscapeString(s)
+}
+
+// URLQuery escapes the value for use as a URL query component.
+func URLQuery(s string) string {
+	return url.QueryEscape(s)
+}
+
+// isPlainYAML reports whether the value can be written as a plain
+// YAML scalar without changing the structure of the document.
+func isPlainYAML(s string) bool {
+	if s == "" || s != strings.TrimSpace(s) {
+		return false
+	}
+	switch c := s[0]; c {
+	case '-', '?', ':':
+		// an indicator followed by a space starts a structure.
+		if len(s) == 1 || s[1] == ' ' {
+			return false
+		}
+	case '[', ']', '{', '}', ',', '#', '&', '*', '!', '|', '>', '\'', '"', '%', '@', '`':
+		return false
+	}
+	if strings.HasSuffix(s, ":") ||
+		strings.Contains(s, ": ") ||
+		strings.Contains(s, " #") ||
+		strings.ContainsAny(s, "[]{},") {
+		return false
+	}
+	for _, r := range s {
+		if r == utf8.RuneError || !unicode.IsPrint(r) {
+			return false
+		}
+	}
+	return !isYAMLNonString(s)
+}
+
+// isYAMLNonString reports whether the plain scalar may be read as a
+// type other than a string, such as a bool, null, number or timestamp.
+// The check is conservative: it includes the YAML 1.1 booleans, such as
+// yes and off, and any value that starts like a number.
+func isYAMLNonString(s string) bool {
+	switch strings.ToLower(s) {
+	case "~", "null", "true", "false", "y", "n", "yes", "no", "on", "off",
+		".nan", ".inf", "+.inf", "-.inf", "<<":
+		return true
+	}
+	// numbers, such as 42, -1, 0x2a, 007, 1_000, .5 and 1e3, and
+	// timestamps, such as 2001-12-14, start with a digit, or with a
+	// sign or a dot followed by a digit.
+	if isDigit(s[0]) {
+		return true
+	}
+	switch s[0] {
+	case '-', '+', '.':
+		if len(s) > 1 && (isDigit(s[1]) || s[1] == '.') {
+			return true
+		}
+	}
+	return false
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
@@ -0,0 +1,83 @@
+package envsubst
+
+import "testing"
+
+func TestEscapers(t *testing.T) {
+	var tests = []struct {
+		name   string
+		escape Escaper
+		input  string
+		output string
+	}{
+		{"json", JSONString, "nginx", "nginx"},
+		{"json", JSONString, "say \"hi\"\n", `say \"hi\"\n`},
+		{"json", JSONString, `C:\temp`, `C:\\temp`},
+		{"json", JSONString, "<a>&\x01\u2028", `<a>&\u0001\u2028`},
+		{"yaml", YAMLScalar, "nginx", "nginx"},
+		{"yaml", YAMLScalar, "-1", `"-1"`},
+		{"yaml", YAMLScalar, "true", `"true"`},
+		{"yaml", YAMLScalar, "Off", `"Off"`},
+		{"yaml", YAMLScalar, "null", `"null"`},
+		{"yaml", YAMLScalar, "~", `"~"`},
+		{"yaml", YAMLScalar, "007", `"007"`},
+		{"yaml", YAMLScalar, "1e3", `"1e3"`},
+		{"yaml", YAMLScalar, ".5", `".5"`},
+		{"yaml", YAMLScalar, ".inf", `".inf"`},
+		{"yaml", YAMLScalar, "2001-12-14", `"2001-12-14"`},
+		{"yaml", YAMLScalar, "v1.2", "v1.2"},
+		{"yaml", YAMLScalar, "-a", "-a"},
+		{"yaml", YAMLScalar, "truely", "truely"},
+		{"yaml", YAMLScalar, "http://example.com:80/a", "http://example.com:80/a"},
+		{"yaml", YAMLScalar, "it's", "it's"},
+		{"yaml", YAMLScalar, "", `""`},
+		{"yaml", YAMLScalar, "a: b", `"a: b"`},
+		{"yaml", YAMLScalar, "a:", `"a:"`},
+		{"yaml", YAMLScalar, "- a", `"- a"`},
+		{"yaml", YAMLScalar, "#a", `"#a"`},
+		{"yaml", YAMLScalar, "a #b", `"a #b"`},
+		{"yaml", YAMLScalar, "a,b", `"a,b"`},
+		{"yaml", YAMLScalar, " a", `" a"`},
+		{"yaml", YAMLScalar, `"a"`, `"\"a\""`},
+		{"yaml", YAMLScalar, "a\nb", `"a\nb"`},
+		{"yaml", YAMLScalar, "a\tb", `"a\tb"`},
+		{"shell", ShellQuote, "", "''"},
+		{"shell", ShellQuote, "a b", "'a b'"},
+		{"shell", ShellQuote, "it's $HOME", `'it'\''s $HOME'`},
+		{"xml", XMLText, `<a href="x">&'`, "&lt;a href=&#34;x&#34;&gt;&amp;&#39;"},
+		{"url", URLQuery, "a b&c=d/é", "a+b%26c%3Dd%2F%C3%A9"},
+	}
+	for _, test := range tests {
+		if got := test.escape(test.input); got != test.output {
+			t.Errorf("Want %s escaper to escape %q as %q, got %q", test.name, test.input, test.output, got)
+		}
+	}
+}
+
+func TestExpandEscaper(t *testing.T) {
+	params := map[string]string{"name": `say "hi"`, "quote": `"`}
+	mapping := func(s string) string { return params[s] }
+
+	var expressions = []struct {
+		input  string
+		output string
+	}{
+		// literal text is not escaped.
+		{`{"msg": "${name}"}`, `{"msg": "say \"hi\""}`},
+		{`"${unset:-"}"`, `"\""`},
+		// nested substitutions are escaped once.
+		{`"${unset:-${quote}x}"`, `"\"x"`},
+		{`"${name/hi/${quote}}"`, `"say \"\"\""`},
+		{`"$${name}"`, `"${name}"`},
+	}
+	for _, expr := range expressions {
+		tmpl, err := Parse(expr.input, WithEscaper(JSONString))
+		if err != nil {
+			t.Errorf("Want %q parsed without error, got %v", expr.input, err)
+			continue
+		}
+		got, _ := tmpl.Execute(mapping)
+		if got != expr.output {
+			t.Errorf("Want %q expanded to %q, got %q", expr.input, expr.output, got)
+		}
+	}
+}
@@ -1,19 +1,57 @@
 package envsubst
 
-import "os"
+import (
+	"io"
+	"os"
+	"strings"
+)
 
 // Eval replaces ${var} in the string based on the mapping function.
+// The mapping function cannot distinguish between unset and empty
+// variables, so both are treated as unset. The parsed string is
+// cached, see Compile.
 func Eval(s string, mapping func(string) string) (string, error) {
-	t, err := Parse(s)
+	t, err := Compile(s)
 	if err != nil {
 		return s, err
 	}
 	return t.Execute(mapping)
 }
 
+// EvalReader replaces ${var} in the input read from r based on the
+// mapping function, and writes the output to w. The input is read and
+// parsed in its entirety before the output is written, and the output
+// is streamed to w. The parsed template refers to the input rather
+// than copying it, except for text from which escapes are removed.
+func EvalReader(r io.Reader, w io.Writer, mapping func(string) string) error {
+	// the builder returns its buffer as the string without a copy.
+	var b strings.Builder
+	if _, err := io.Copy(&b, r); err != nil {
+		return err
+	}
+	t, err := Parse(b.String())
+	if err != nil {
+		return err
+	}
+	return t.ExecuteTo(w, mapping)
+}
+
+// EvalLookup replaces ${var} in the string based on the lookup function.
+// The lookup function reports whether the variable is set, in the same
+// manner as os.LookupEnv, which allows operators such as ${var-default}
+// and ${var:-default} to follow posix semantics. The parsed string is
+// cached, see Compile.
+func EvalLookup(s string, lookup func(string) (string, bool)) (string, error) {
+	t, err := Compile(s)
+	if err != nil {
+		return s, err
+	}
+	return t.ExecuteLookup(lookup)
+}
+
 // EvalEnv replaces ${var} in the string according to the values of the
 // current environment variables. References to undefined variables are
 // replaced by the empty string.
 func EvalEnv(s string) (string, error) {
-	return Eval(s, os.Getenv)
+	return EvalLookup(s, os.LookupEnv)
 }
@@ -1,228 +1,1221 @@
 package envsubst
 
-import "testing"
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/drone/envsubst/v2/parse"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
 
 // test cases sourced from tldp.org
 // http://www.tldp.org/LDP/abs/html/parameter-substitution.html
 
+var expandTests = []struct {
+	params map[string]string
+	input  string
+	output string
+}{
+	// text-only
+	{
+		params: map[string]string{},
+		input:  "abcdEFGH28ij",
+		output: "abcdEFGH28ij",
+	},
+	// length
+	{
+		params: map[string]string{"var01": "abcdEFGH28ij"},
+		input:  "${#var01}",
+		output: "12",
+	},
+	// uppercase first
+	{
+		params: map[string]string{"var01": "abcdEFGH28ij"},
+		input:  "${var01^}",
+		output: "AbcdEFGH28ij",
+	},
+	// uppercase
+	{
+		params: map[string]string{"var01": "abcdEFGH28ij"},
+		input:  "${var01^^}",
+		output: "ABCDEFGH28IJ",
+	},
+	// lowercase first
+	{
+		params: map[string]string{"var01": "ABCDEFGH28IJ"},
+		input:  "${var01,}",
+		output: "aBCDEFGH28IJ",
+	},
+	// lowercase
+	{
+		params: map[string]string{"var01": "ABCDEFGH28IJ"},
+		input:  "${var01,,}",
+		output: "abcdefgh28ij",
+	},
+	// substring with position
+	{
+		params: map[string]string{"path_name": "/home/bozo/ideas/thoughts.for.today"},
+		input:  "${path_name:11}",
+		output: "ideas/thoughts.for.today",
+	},
+	// substring with position and length
+	{
+		params: map[string]string{"path_name": "/home/bozo/ideas/thoughts.for.today"},
+		input:  "${path_name:11:5}",
+		output: "ideas",
+	},
+	// default not used
+	{
+		params: map[string]string{"var": "abc"},
+		input:  "${var=xyz}",
+		output: "abc",
+	},
+	// default used
+	{
+		params: map[string]string{},
+		input:  "${var=xyz}",
+		output: "xyz",
+	},
+	{
+		params: map[string]string{"default_var": "foo"},
+		input:  "something ${var=${default_var}}",
+		output: "something foo",
+	},
+	{
+		params: map[string]string{"default_var": "foo1"},
+		input:  `foo: ${var=${default_var}-suffix}`,
+		output: "foo: foo1-suffix",
+	},
+	{
+		params: map[string]string{"default_var": "foo1"},
+		input:  `foo: ${var=prefix${default_var}-suffix}`,
+		output: "foo: prefixfoo1-suffix",
+	},
+	{
+		params: map[string]string{},
+		input:  "${var:=xyz}",
+		output: "xyz",
+	},
+	// replace suffix
+	{
+		params: map[string]string{"stringZ": "abcABC123ABCabc"},
+		input:  "${stringZ/%abc/XYZ}",
+		output: "abcABC123ABCXYZ",
+	},
+	// replace prefix
+	{
+		params: map[string]string{"stringZ": "abcABC123ABCabc"},
+		input:  "${stringZ/#abc/XYZ}",
+		output: "XYZABC123ABCabc",
+	},
+	// replace all
+	{
+		params: map[string]string{"stringZ": "abcABC123ABCabc"},
+		input:  "${stringZ//abc/xyz}",
+		output: "xyzABC123ABCxyz",
+	},
+	// replace first
+	{
+		params: map[string]string{"stringZ": "abcABC123ABCabc"},
+		input:  "${stringZ/abc/xyz}",
+		output: "xyzABC123ABCabc",
+	},
+	// replace with pattern, verified with bash
+	{
+		params: map[string]string{"v": "abcABC123ABCabc"},
+		input:  "${v//[0-9]/#}",
+		output: "abcABC###ABCabc",
+	},
+	{
+		params: map[string]string{"v": "abcABC123ABCabc"},
+		input:  "${v/a*b/x}",
+		output: "xc",
+	},
+	{
+		params: map[string]string{"v": "abcABC123ABCabc"},
+		input:  "${v//b*/Q}",
+		output: "aQ",
+	},
+	{
+		params: map[string]string{"v": "abcABC123ABCabc"},
+		input:  "${v//?/.}",
+		output: "...............",
+	},
+	{
+		params: map[string]string{"v": "abcABC123ABCabc"},
+		input:  "${v/#*C/Z}",
+		output: "Zabc",
+	},
+	{
+		params: map[string]string{"v": "abcABC123ABCabc"},
+		input:  "${v/%[a-c]*/Z}",
+		output: "Z",
+	},
+	{
+		params: map[string]string{"v": "abcABC123ABCabc"},
+		input:  "${v/%B*/Z}",
+		output: "abcAZ",
+	},
+	{
+		params: map[string]string{"v": "abcABC123ABCabc"},
+		input:  "${v/#/x}",
+		output: "xabcABC123ABCabc",
+	},
+	{
+		params: map[string]string{"v": "abcABC123ABCabc"},
+		input:  "${v/%/x}",
+		output: "abcABC123ABCabcx",
+	},
+	{
+		params: map[string]string{"v": "abcABC123ABCabc"},
+		input:  "${v/#abc/}",
+		output: "ABC123ABCabc",
+	},
+	{
+		params: map[string]string{"v": "abcABC123ABCabc"},
+		input:  "${v//[!a-z]/-}",
+		output: "abc---------abc",
+	},
+	{
+		params: map[string]string{"v": "abcABC123ABCabc"},
+		input:  "${v/[A-Z][A-Z]/_}",
+		output: "abc_C123ABCabc",
+	},
+	{
+		params: map[string]string{"v": "1.2.3"},
+		input:  "${v//./-}",
+		output: "1-2-3",
+	},
+	{
+		params: map[string]string{"v": "héllo wörld"},
+		input:  "${v//?/x}",
+		output: "xxxxxxxxxxx",
+	},
+	{
+		params: map[string]string{"v": "héllo wörld"},
+		input:  "${v/ö*/o}",
+		output: "héllo wo",
+	},
+	// delete shortest match prefix
+	{
+		params: map[string]string{"filename": "bash.string.txt"},
+		input:  "${filename#*.}",
+		output: "string.txt",
+	},
+	{
+		params: map[string]string{"filename": "path/to/file"},
+		input:  "${filename#*/}",
+		output: "to/file",
+	},
+	{
+		params: map[string]string{"filename": "/path/to/file"},
+		input:  "${filename#*/}",
+		output: "path/to/file",
+	},
+	// delete longest match prefix
+	{
+		params: map[string]string{"filename": "bash.string.txt"},
+		input:  "${filename##*.}",
+		output: "txt",
+	},
+	{
+		params: map[string]string{"filename": "path/to/file"},
+		input:  "${filename##*/}",
+		output: "file",
+	},
+	{
+		params: map[string]string{"filename": "/path/to/file"},
+		input:  "${filename##*/}",
+		output: "file",
+	},
+	// delete shortest match suffix
+	{
+		params: map[string]string{"filename": "bash.string.txt"},
+		input:  "${filename%.*}",
+		output: "bash.string",
+	},
+	// delete longest match suffix
+	{
+		params: map[string]string{"filename": "bash.string.txt"},
+		input:  "${filename%%.*}",
+		output: "bash",
+	},
+
+	// trim with pattern, verified with bash
+	{
+		params: map[string]string{"v": "v1.2.3"},
+		input:  "${v#v}",
+		output: "1.2.3",
+	},
+	{
+		params: map[string]string{"v": "v1.2.3"},
+		input:  "${v#[[:alpha:]]}",
+		output: "1.2.3",
+	},
+	{
+		params: map[string]string{"v": "v1.2.3"},
+		input:  "${v%.*}",
+		output: "v1.2",
+	},
+	{
+		params: map[string]string{"v": "v1.2.3"},
+		input:  "${v%%.*}",
+		output: "v1",
+	},
+	{
+		params: map[string]string{"v": "abcDEF"},
+		input:  "${v%[a-z]*}",
+		output: "ab",
+	},
+	{
+		params: map[string]string{"v": "abcDEF"},
+		input:  "${v%%[a-z]*}",
+		output: "",
+	},
+	{
+		params: map[string]string{"v": "abcDEF"},
+		input:  "${v%[A-Z]}",
+		output: "abcDE",
+	},
+	{
+		params: map[string]string{"v": "abcDEF"},
+		input:  "${v#*}",
+		output: "abcDEF",
+	},
+	{
+		params: map[string]string{"v": "abcDEF"},
+		input:  "${v##*}",
+		output: "",
+	},
+	{
+		params: map[string]string{"v": "abcDEF"},
+		input:  "${v%*}",
+		output: "abcDEF",
+	},
+	{
+		params: map[string]string{"v": "path/to/file.txt"},
+		input:  "${v%/*}",
+		output: "path/to",
+	},
+	{
+		params: map[string]string{"v": "path/to/file.txt"},
+		input:  "${v##*/}",
+		output: "file.txt",
+	},
+	{
+		params: map[string]string{"v": "a[b]c"},
+		input:  "${v#a[}",
+		output: "b]c",
+	},
+	{
+		params: map[string]string{"v": "héllo"},
+		input:  "${v#h?}",
+		output: "llo",
+	},
+	{
+		params: map[string]string{"v": "héllo"},
+		input:  "${v%l?}",
+		output: "hél",
+	},
+	{
+		params: map[string]string{"v": "2024-01-15"},
+		input:  "${v%%-[[:digit:]][[:digit:]]}",
+		output: "2024-01",
+	},
+
+	// nested parameters
+	{
+		params: map[string]string{"var01": "abcdEFGH28ij"},
+		input:  "${var=${var01^^}}",
+		output: "ABCDEFGH28IJ",
+	},
+	// escaped
+	{
+		params: map[string]string{"var01": "abcdEFGH28ij"},
+		input:  "$${var01}",
+		output: "${var01}",
+	},
+	{
+		params: map[string]string{"var01": "abcdEFGH28ij"},
+		input:  "some text ${var01}$${var$${var01}$var01${var01}",
+		output: "some text abcdEFGH28ij${var${var01}$var01abcdEFGH28ij",
+	},
+	{
+		params: map[string]string{"default_var": "foo"},
+		input:  "something $${var=${default_var}}",
+		output: "something ${var=foo}",
+	},
+	// some common escaping use cases
+	{
+		params: map[string]string{"stringZ": "foo/bar"},
+		input:  `${stringZ/\//-}`,
+		output: "foo-bar",
+	},
+	{
+		params: map[string]string{"stringZ": "foo/bar/baz"},
+		input:  `${stringZ//\//-}`,
+		output: "foo-bar-baz",
+	},
+	// escape outside of expansion shouldn't be processed
+	{
+		params: map[string]string{"default_var": "foo"},
+		input:  "\\\\something ${var=${default_var}}",
+		output: "\\\\something foo",
+	},
+	// substitute with a blank string
+	{
+		params: map[string]string{"stringZ": "foo.bar"},
+		input:  `${stringZ/./}`,
+		output: "foobar",
+	},
+}
+
 func TestExpand(t *testing.T) {
+	for _, expr := range expandTests {
+		t.Run(expr.input, func(t *testing.T) {
+			t.Logf(expr.input)
+			output, err := Eval(expr.input, func(s string) string {
+				return expr.params[s]
+			})
+			if err != nil {
+				t.Errorf("Want %q expanded but got error %q", expr.input, err)
+			}
+
+			if output != expr.output {
+				t.Errorf("Want %q expanded to %q, got %q",
+					expr.input,
+					expr.output,
+					output)
+			}
+		})
+	}
+}
+
+func TestExpandLookup(t *testing.T) {
 	var expressions = []struct {
 		params map[string]string
 		input  string
 		output string
 	}{
-		// text-only
+		// default used when unset
 		{
 			params: map[string]string{},
-			input:  "abcdEFGH28ij",
-			output: "abcdEFGH28ij",
+			input:  "${var-xyz}",
+			output: "xyz",
 		},
-		// length
 		{
-			params: map[string]string{"var01": "abcdEFGH28ij"},
-			input:  "${#var01}",
-			output: "12",
+			params: map[string]string{},
+			input:  "${var:-xyz}",
+			output: "xyz",
 		},
-		// uppercase first
 		{
-			params: map[string]string{"var01": "abcdEFGH28ij"},
-			input:  "${var01^}",
-			output: "AbcdEFGH28ij",
+			params: map[string]string{},
+			input:  "${var=xyz}",
+			output: "xyz",
 		},
-		// uppercase
 		{
-			params: map[string]string{"var01": "abcdEFGH28ij"},
-			input:  "${var01^^}",
-			output: "ABCDEFGH28IJ",
+			params: map[string]string{},
+			input:  "${var:=xyz}",
+			output: "xyz",
 		},
-		// lowercase first
+		// default used when empty only with a colon
 		{
-			params: map[string]string{"var01": "ABCDEFGH28IJ"},
-			input:  "${var01,}",
-			output: "aBCDEFGH28IJ",
+			params: map[string]string{"var": ""},
+			input:  "${var-xyz}",
+			output: "",
 		},
-		// lowercase
 		{
-			params: map[string]string{"var01": "ABCDEFGH28IJ"},
-			input:  "${var01,,}",
-			output: "abcdefgh28ij",
+			params: map[string]string{"var": ""},
+			input:  "${var:-xyz}",
+			output: "xyz",
 		},
-		// substring with position
 		{
-			params: map[string]string{"path_name": "/home/bozo/ideas/thoughts.for.today"},
-			input:  "${path_name:11}",
-			output: "ideas/thoughts.for.today",
+			params: map[string]string{"var": ""},
+			input:  "${var=xyz}",
+			output: "",
 		},
-		// substring with position and length
 		{
-			params: map[string]string{"path_name": "/home/bozo/ideas/thoughts.for.today"},
-			input:  "${path_name:11:5}",
-			output: "ideas",
+			params: map[string]string{"var": ""},
+			input:  "${var:=xyz}",
+			output: "xyz",
 		},
-		// default not used
+		// default not used when set
 		{
 			params: map[string]string{"var": "abc"},
-			input:  "${var=xyz}",
+			input:  "${var-xyz}",
 			output: "abc",
 		},
-		// default used
+		{
+			params: map[string]string{"var": "abc"},
+			input:  "${var:-xyz}",
+			output: "abc",
+		},
+		// alternate value
 		{
 			params: map[string]string{},
-			input:  "${var=xyz}",
+			input:  "${var+xyz}",
+			output: "",
+		},
+		{
+			params: map[string]string{},
+			input:  "${var:+xyz}",
+			output: "",
+		},
+		{
+			params: map[string]string{"var": ""},
+			input:  "${var+xyz}",
 			output: "xyz",
 		},
 		{
-			params: map[string]string{"default_var": "foo"},
-			input:  "something ${var=${default_var}}",
-			output: "something foo",
+			params: map[string]string{"var": ""},
+			input:  "${var:+xyz}",
+			output: "",
+		},
+		{
+			params: map[string]string{"var": "abc"},
+			input:  "${var+xyz}",
+			output: "xyz",
 		},
 		{
-			params: map[string]string{"default_var": "foo1"},
-			input:  `foo: ${var=${default_var}-suffix}`,
-			output: "foo: foo1-suffix",
+			params: map[string]string{"DEBUG": "true"},
+			input:  "run ${DEBUG:+--verbose} ${QUIET:+--quiet}",
+			output: "run --verbose ",
 		},
 		{
-			params: map[string]string{"default_var": "foo1"},
-			input:  `foo: ${var=prefix${default_var}-suffix}`,
-			output: "foo: prefixfoo1-suffix",
+			params: map[string]string{"var": "abc", "flag": "x"},
+			input:  "${var:+--flag=${flag}}",
+			output: "--flag=x",
 		},
+		// alternate value not evaluated when null
 		{
 			params: map[string]string{},
-			input:  "${var:=xyz}",
-			output: "xyz",
+			input:  "${var:+${other?}}",
+			output: "",
 		},
-		// replace suffix
+		// default assigned to the variable
 		{
-			params: map[string]string{"stringZ": "abcABC123ABCabc"},
-			input:  "${stringZ/%abc/XYZ}",
-			output: "abcABC123ABCXYZ",
+			params: map[string]string{},
+			input:  "${var=xyz} ${var}",
+			output: "xyz xyz",
 		},
-		// replace prefix
 		{
-			params: map[string]string{"stringZ": "abcABC123ABCabc"},
-			input:  "${stringZ/#abc/XYZ}",
-			output: "XYZABC123ABCabc",
+			params: map[string]string{"var": ""},
+			input:  "${var:=xyz} ${var}",
+			output: "xyz xyz",
 		},
-		// replace all
 		{
-			params: map[string]string{"stringZ": "abcABC123ABCabc"},
-			input:  "${stringZ//abc/xyz}",
-			output: "xyzABC123ABCxyz",
+			params: map[string]string{},
+			input:  "${var:-xyz} ${var}",
+			output: "xyz ",
 		},
-		// replace first
+		// empty matches are replaced, verified with bash
 		{
-			params: map[string]string{"stringZ": "abcABC123ABCabc"},
-			input:  "${stringZ/abc/xyz}",
-			output: "xyzABC123ABCabc",
+			params: map[string]string{"var": ""},
+			input:  "${var//*/R} ${var/*/R} ${var/#/R} ${var/%/R}",
+			output: "R R R R",
 		},
-		// delete shortest match prefix
 		{
-			params: map[string]string{"filename": "bash.string.txt"},
-			input:  "${filename#*.}",
-			output: "string.txt",
+			params: map[string]string{},
+			input:  "${var//*/R}${var/#/R}${var/%/R}",
+			output: "",
 		},
+		// nested default
 		{
-			params: map[string]string{"filename": "path/to/file"},
-			input:  "${filename#*/}",
-			output: "to/file",
+			params: map[string]string{"default_var": ""},
+			input:  "${var-${default_var-foo}}",
+			output: "",
 		},
 		{
-			params: map[string]string{"filename": "/path/to/file"},
-			input:  "${filename#*/}",
-			output: "path/to/file",
+			params: map[string]string{},
+			input:  "${var-${default_var-foo}}",
+			output: "foo",
+		},
+	}
+
+	for _, expr := range expressions {
+		t.Run(expr.input, func(t *testing.T) {
+			output, err := EvalLookup(expr.input, func(s string) (string, bool) {
+				v, ok := expr.params[s]
+				return v, ok
+			})
+			if err != nil {
+				t.Errorf("Want %q expanded but got error %q", expr.input, err)
+			}
+
+			if output != expr.output {
+				t.Errorf("Want %q expanded to %q, got %q",
+					expr.input,
+					expr.output,
+					output)
+			}
+		})
+	}
+}
+
+func TestExpandRequired(t *testing.T) {
+	var expressions = []struct {
+		params map[string]string
+		input  string
+		err    *RequiredVariableError
+		output string
+	}{
+		{
+			params: map[string]string{"var": "abc"},
+			input:  "${var:?required}",
+			output: "abc",
 		},
-		// delete longest match prefix
 		{
-			params: map[string]string{"filename": "bash.string.txt"},
-			input:  "${filename##*.}",
-			output: "txt",
+			params: map[string]string{"var": ""},
+			input:  "${var?required}",
+			output: "",
 		},
 		{
-			params: map[string]string{"filename": "path/to/file"},
-			input:  "${filename##*/}",
-			output: "file",
+			params: map[string]string{"var": ""},
+			input:  "foo ${var:?var is ${msg:-empty}}",
+			err:    &RequiredVariableError{Name: "var", Message: "var is empty", Pos: 4},
 		},
 		{
-			params: map[string]string{"filename": "/path/to/file"},
-			input:  "${filename##*/}",
-			output: "file",
+			params: map[string]string{},
+			input:  "${var?}",
+			err:    &RequiredVariableError{Name: "var", Pos: 0},
 		},
-		// delete shortest match suffix
+		// message not evaluated when set
 		{
-			params: map[string]string{"filename": "bash.string.txt"},
-			input:  "${filename%.*}",
-			output: "bash.string",
+			params: map[string]string{"var": "abc"},
+			input:  "${var:?${other?}}",
+			output: "abc",
 		},
-		// delete longest match suffix
+		// required variable within a default
 		{
-			params: map[string]string{"filename": "bash.string.txt"},
-			input:  "${filename%%.*}",
-			output: "bash",
+			params: map[string]string{},
+			input:  "${var:-${other:?other is required}}",
+			err:    &RequiredVariableError{Name: "other", Message: "other is required", Pos: 7},
 		},
+	}
 
-		// nested parameters
-		{
-			params: map[string]string{"var01": "abcdEFGH28ij"},
-			input:  "${var=${var01^^}}",
-			output: "ABCDEFGH28IJ",
+	for _, expr := range expressions {
+		t.Run(expr.input, func(t *testing.T) {
+			output, err := EvalLookup(expr.input, func(s string) (string, bool) {
+				v, ok := expr.params[s]
+				return v, ok
+			})
+			if expr.err == nil {
+				if err != nil {
+					t.Errorf("Want %q expanded but got error %q", expr.input, err)
+				}
+				if output != expr.output {
+					t.Errorf("Want %q expanded to %q, got %q", expr.input, expr.output, output)
+				}
+				return
+			}
+			got, ok := err.(*RequiredVariableError)
+			if !ok {
+				t.Fatalf("Want RequiredVariableError, got %v", err)
+			}
+			if *got != *expr.err {
+				t.Errorf("Want error %+v, got %+v", expr.err, got)
+			}
+		})
+	}
+}
+
+func TestExpandBare(t *testing.T) {
+	params := map[string]string{"HOME": "/home/bozo", "1": "one"}
+	mapping := func(s string) string {
+		return params[s]
+	}
+
+	tmpl, err := Parse("$HOME/bin ${HOME} $1 $$HOME")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := tmpl.Execute(mapping)
+	if want := "$HOME/bin /home/bozo $1 $HOME"; got != want {
+		t.Errorf("Want unbraced variables ignored by default, got %q", got)
+	}
+
+	tmpl, err = Parse("$HOME/bin ${HOME} $1 $$HOME", WithBareVariables())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ = tmpl.Execute(mapping)
+	if want := "/home/bozo/bin /home/bozo one $HOME"; got != want {
+		t.Errorf("Want unbraced variables expanded, got %q", got)
+	}
+}
+
+func TestExpandByteMode(t *testing.T) {
+	// expected values verified with bash in the C.UTF-8 and C locales
+	var expressions = []struct {
+		input string
+		runes string
+		bytes string
+	}{
+		{"${#v}", "4", "6"},
+		{"${v:0:2}", "Zo", "Zo"},
+		{"${v:2}", "ëé", "ëé"},
+		{"${v:1:10}", "oëé", "oëé"},
+		{"${v:5}", "", "\xa9"},
+	}
+	lookup := func(string) (string, bool) { return "Zoëé", true }
+	for _, expr := range expressions {
+		tmpl, err := Parse(expr.input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := tmpl.ExecuteLookup(lookup); got != expr.runes {
+			t.Errorf("Want %q expanded to %q, got %q", expr.input, expr.runes, got)
+		}
+
+		tmpl, err = Parse(expr.input, WithByteMode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := tmpl.ExecuteLookup(lookup); got != expr.bytes {
+			t.Errorf("Want %q expanded to %q in byte mode, got %q", expr.input, expr.bytes, got)
+		}
+	}
+}
+
+func TestExpandArithmetic(t *testing.T) {
+	params := map[string]string{"v": "abcdefgh", "n": "2", "m": "n+1"}
+	lookup := func(name string) (string, bool) {
+		v, ok := params[name]
+		return v, ok
+	}
+
+	// expected values verified with bash
+	var expressions = []struct {
+		input  string
+		output string
+	}{
+		{"${v:1+2:${#v}-4}", "defg"},
+		{"${v: -2}", "gh"},
+		{"${v:(-2):1}", "g"},
+		{"${v:n}", "cdefgh"},
+		{"${v:n*2:n}", "ef"},
+		{"${v:1:-1}", "bcdefg"},
+		{"${v::2}", "ab"},
+		{"${v:m}", "defgh"},
+		{"x$((1+2))", "x3"},
+		{"$(( n * (n+1) ))", "6"},
+		{"$((m*2))", "6"},
+		{"$((${#v} % 3))", "2"},
+		{"$(($n<<2))", "8"},
+		{"$((u+1))", "1"},
+		{"$$((n))", "$((n))"},
+	}
+	for _, expr := range expressions {
+		tmpl, err := Parse(expr.input, WithArithmetic())
+		if err != nil {
+			t.Errorf("Want %q parsed without error, got %v", expr.input, err)
+			continue
+		}
+		got, err := tmpl.ExecuteLookup(lookup)
+		if err != nil {
+			t.Errorf("Want %q executed without error, got %v", expr.input, err)
+			continue
+		}
+		if got != expr.output {
+			t.Errorf("Want %q expanded to %q, got %q", expr.input, expr.output, got)
+		}
+	}
+
+	// arithmetic expansions are literal text by default.
+	got, _ := EvalLookup("$((n+1))", lookup)
+	if want := "$((n+1))"; got != want {
+		t.Errorf("Want arithmetic expansions disabled by default, got %q", got)
+	}
+
+	tmpl, err := Parse("x=$((${n}/${z:-0}))", WithArithmetic())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = tmpl.ExecuteLookup(lookup)
+	if !errors.Is(err, parse.ErrDivisionByZero) {
+		t.Fatalf("Want division by zero error, got %v", err)
+	}
+	if got, want := err.Error(), "2/0: division by 0"; got != want {
+		t.Errorf("Want error %q, got %q", want, got)
+	}
+	if got, want := err.(*ArithmeticError).Pos, 2; got != want {
+		t.Errorf("Want error position %d, got %d", want, got)
+	}
+}
+
+func TestExpandExtGlob(t *testing.T) {
+	// expected values verified with bash -O extglob
+	var expressions = []struct {
+		input  string
+		output string
+	}{
+		{"${v##+([0-9])}", ".34.5"},
+		{"${v#+([0-9])}", "2.34.5"},
+		{"${v%%.*([0-9])}", "12.34"},
+		{"${v//@(1|2)/x}", "xx.34.5"},
+		{"${v%.!(x)}", "12.34"},
+		{"${v/#v+([0-9])./}", "12.34.5"},
+		{"${v/?(z)/R}", "R12.34.5"},
+		{"${v//*(z)/R}", "R1R2R.R3R4R.R5"},
+		{"${v//*([0-9])/x}", "xx.xx.x"},
+	}
+	lookup := func(string) (string, bool) { return "12.34.5", true }
+	for _, expr := range expressions {
+		tmpl, err := Parse(expr.input, WithExtGlob())
+		if err != nil {
+			t.Errorf("Want %q parsed without error, got %v", expr.input, err)
+			continue
+		}
+		got, _ := tmpl.ExecuteLookup(lookup)
+		if got != expr.output {
+			t.Errorf("Want %q expanded to %q, got %q", expr.input, expr.output, got)
+		}
+	}
+
+	// extended patterns are literal text by default.
+	got, _ := Eval("${v##+([0-9])}", func(string) string { return "+(1)2" })
+	if want := "2"; got != want {
+		t.Errorf("Want extended patterns disabled by default, got %q", got)
+	}
+}
+
+func TestExpandFuncs(t *testing.T) {
+	funcs := map[string]Func{
+		"base64": func(s string) string {
+			return base64.StdEncoding.EncodeToString([]byte(s))
+		},
+		"sha256": func(s string) string {
+			sum := sha256.Sum256([]byte(s))
+			return hex.EncodeToString(sum[:])
 		},
-		// escaped
+		"quote": strconv.Quote,
+		"trim":  strings.TrimSpace,
+	}
+	params := map[string]string{"user": " admin ", "pass": "secret"}
+
+	var expressions = []struct {
+		input  string
+		output string
+	}{
+		{"${user|trim}", "admin"},
+		{"${user|quote}", `" admin "`},
+		{"${user|trim|base64}", "YWRtaW4="},
+		{"${pass|sha256}", "2bb80d537b1da3e38bd30361aa855686bde0eacd7162fef6a25fe97bf527a25b"},
+		{"${unset|quote}", `""`},
+		{"${unset:-${user|trim}}", "admin"},
+		{"$${user|trim}", "${user|trim}"},
+	}
+	for _, expr := range expressions {
+		tmpl, err := Parse(expr.input, Funcs(funcs))
+		if err != nil {
+			t.Errorf("Want %q parsed without error, got %v", expr.input, err)
+			continue
+		}
+		got, _ := tmpl.Execute(func(s string) string { return params[s] })
+		if got != expr.output {
+			t.Errorf("Want %q expanded to %q, got %q", expr.input, expr.output, got)
+		}
+	}
+
+	// functions added by multiple options are merged.
+	tmpl, err := Parse("${user|trim|upper}", Funcs(funcs), Funcs(map[string]Func{"upper": strings.ToUpper}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := tmpl.Execute(func(s string) string { return params[s] }); got != "ADMIN" {
+		t.Errorf("Want merged functions applied, got %q", got)
+	}
+	want := []Variable{{Name: "user", Operators: []string{"|trim|upper"}}}
+	if got := tmpl.Variables(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Want variables %+v, got %+v", want, got)
+	}
+
+	// undefined functions are parse errors.
+	_, err = Parse("${user|rot13}", Funcs(funcs))
+	if !errors.Is(err, parse.ErrUnknownFunction) {
+		t.Errorf("Want unknown function error, got %v", err)
+	}
+	if err != nil && !strings.HasSuffix(err.Error(), "unknown function: rot13") {
+		t.Errorf("Want error naming the function, got %v", err)
+	}
+}
+
+func TestParseFileError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "envsubst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "file.yaml")
+	err = ioutil.WriteFile(path, []byte("name: ${NAME}\nimage: ${IMAGE:-${TAG}\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ParseFile(path)
+	if !errors.Is(err, parse.ErrMissingClosingBrace) {
+		t.Fatalf("Want missing closing brace error, got %v", err)
+	}
+	if got, want := err.Error(), path+":2:8: missing closing brace"; got != want {
+		t.Errorf("Want error %q, got %q", want, got)
+	}
+}
+
+func TestExpandNoUnset(t *testing.T) {
+	var expressions = []struct {
+		params map[string]string
+		input  string
+		output string
+		unset  []string
+	}{
 		{
-			params: map[string]string{"var01": "abcdEFGH28ij"},
-			input:  "$${var01}",
-			output: "${var01}",
+			params: map[string]string{"var": ""},
+			input:  "${var} ${var^^}",
+			output: " ",
 		},
 		{
-			params: map[string]string{"var01": "abcdEFGH28ij"},
-			input:  "some text ${var01}$${var$${var01}$var01${var01}",
-			output: "some text abcdEFGH28ij${var${var01}$var01abcdEFGH28ij",
+			params: map[string]string{},
+			input:  "${var-x} ${var:-y} ${var+z} ${var:+z}",
+			output: "x y  ",
 		},
 		{
-			params: map[string]string{"default_var": "foo"},
-			input:  "something $${var=${default_var}}",
-			output: "something ${var=foo}",
+			params: map[string]string{},
+			input:  "${var=x} ${var}",
+			output: "x x",
 		},
-		// some common escaping use cases
 		{
-			params: map[string]string{"stringZ": "foo/bar"},
-			input:  `${stringZ/\//-}`,
-			output: "foo-bar",
+			params: map[string]string{"var": "abc"},
+			input:  "${DATABSE_URL} ${var} ${#other} ${DATABSE_URL}",
+			unset:  []string{"DATABSE_URL", "other"},
 		},
+		// default evaluated when var is unset
 		{
-			params: map[string]string{"stringZ": "foo/bar/baz"},
-			input:  `${stringZ//\//-}`,
-			output: "foo-bar-baz",
+			params: map[string]string{},
+			input:  "${var:-${other}}",
+			unset:  []string{"other"},
 		},
-		// escape outside of expansion shouldn't be processed
+		// default not evaluated when var is set
 		{
-			params: map[string]string{"default_var": "foo"},
-			input:  "\\\\something ${var=${default_var}}",
-			output: "\\\\something foo",
+			params: map[string]string{"var": "abc"},
+			input:  "${var:-${other}}",
+			output: "abc",
 		},
-		// substitute with a blank string
+		// variable names in substring offsets
 		{
-			params: map[string]string{"stringZ": "foo.bar"},
-			input:  `${stringZ/./}`,
-			output: "foobar",
+			params: map[string]string{"var": "abc"},
+			input:  "${var:offset}",
+			unset:  []string{"offset"},
 		},
 	}
 
 	for _, expr := range expressions {
 		t.Run(expr.input, func(t *testing.T) {
-			t.Logf(expr.input)
-			output, err := Eval(expr.input, func(s string) string {
-				return expr.params[s]
-			})
+			tmpl, err := Parse(expr.input)
 			if err != nil {
-				t.Errorf("Want %q expanded but got error %q", expr.input, err)
+				t.Fatal(err)
 			}
-
-			if output != expr.output {
-				t.Errorf("Want %q expanded to %q, got %q",
-					expr.input,
-					expr.output,
-					output)
+			output, err := tmpl.ExecuteWith(func(s string) (string, bool) {
+				v, ok := expr.params[s]
+				return v, ok
+			}, ExecuteOptions{NoUnset: true})
+			if expr.unset == nil {
+				if err != nil {
+					t.Errorf("Want %q expanded but got error %q", expr.input, err)
+				}
+				if output != expr.output {
+					t.Errorf("Want %q expanded to %q, got %q", expr.input, expr.output, output)
+				}
+				return
+			}
+			uerr, ok := err.(*UnsetVariableError)
+			if !ok {
+				t.Fatalf("Want UnsetVariableError, got %v", err)
+			}
+			if !reflect.DeepEqual(uerr.Names, expr.unset) {
+				t.Errorf("Want unset variables %v, got %v", expr.unset, uerr.Names)
 			}
 		})
 	}
 }
+
+func TestVariables(t *testing.T) {
+	tmpl, err := Parse("${a} ${b:-${c,,}} ${a^^} ${d:?${a}} ${#e} ${b=x}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Variable{
+		{Name: "a", Operators: []string{"^^"}},
+		{Name: "b", Default: true, Operators: []string{":-", "="}},
+		{Name: "c", Operators: []string{",,"}},
+		{Name: "d", Required: true, Operators: []string{":?"}},
+		{Name: "e", Operators: []string{"#"}},
+	}
+	if got := tmpl.Variables(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Want variables %+v, got %+v", want, got)
+	}
+
+	// variables named in arithmetic expressions.
+	tmpl, err = Parse("$((REPLICAS*2)) ${v:OFFSET:LEN} $((${a}+(b-REPLICAS))) ${v: -c}", WithArithmetic())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []Variable{
+		{Name: "REPLICAS"},
+		{Name: "v", Operators: []string{":"}},
+		{Name: "OFFSET"},
+		{Name: "LEN"},
+		{Name: "b"},
+		{Name: "a"},
+		{Name: "c"},
+	}
+	if got := tmpl.Variables(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Want variables %+v, got %+v", want, got)
+	}
+}
+
+func TestIsSubstitution(t *testing.T) {
+	var tests = []struct {
+		input string
+		subst bool
+	}{
+		{"${PORT}", true},
+		{"${PORT:-8080}", true},
+		{"$((${PORT}+1))", true},
+		{"", false},
+		{"port", false},
+		{"${PORT} ", false},
+		{"${HOST}${PORT}", false},
+	}
+	for _, test := range tests {
+		tmpl, err := Parse(test.input, WithArithmetic())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := tmpl.IsSubstitution(); got != test.subst {
+			t.Errorf("Want IsSubstitution %v for %q, got %v", test.subst, test.input, got)
+		}
+	}
+}
+
+func TestEvalReader(t *testing.T) {
+	params := map[string]string{"name": "web", "image": "nginx"}
+	r := strings.NewReader("name: ${name}\nimage: ${image:-alpine}:${tag:-latest}")
+	w := new(bytes.Buffer)
+	err := EvalReader(r, w, func(s string) string {
+		return params[s]
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := w.String(), "name: web\nimage: nginx:latest"; got != want {
+		t.Errorf("Want output %q, got %q", want, got)
+	}
+
+	r = strings.NewReader("image: ${image")
+	err = EvalReader(r, w, func(s string) string {
+		return params[s]
+	})
+	if !errors.Is(err, parse.ErrMissingClosingBrace) {
+		t.Errorf("Want missing closing brace error, got %v", err)
+	}
+}
+
+func TestExecuteToWith(t *testing.T) {
+	params := map[string]string{"name": "web"}
+	lookup := func(s string) (string, bool) {
+		v, ok := params[s]
+		return v, ok
+	}
+	tmpl, err := Parse("$${name}: ${name}/${tag-latest}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := new(bytes.Buffer)
+	if err := tmpl.ExecuteToWith(w, lookup, ExecuteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := w.String(), "${name}: web/latest"; got != want {
+		t.Errorf("Want output %q, got %q", want, got)
+	}
+
+	tmpl, err = Parse("${name}/${tag}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tmpl.ExecuteToWith(new(bytes.Buffer), lookup, ExecuteOptions{NoUnset: true})
+	if uerr, ok := err.(*UnsetVariableError); !ok || len(uerr.Names) != 1 || uerr.Names[0] != "tag" {
+		t.Errorf("Want unset variable error for tag, got %v", err)
+	}
+}
+
+func TestExpandPreserve(t *testing.T) {
+	params := map[string]string{"PORT": "8080", "host": "example.com"}
+	tmpl, err := Parse("listen ${PORT}; proxy_set_header Host $host; return ${host:-x}${PORT:+/${uri}};", WithBareVariables())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := tmpl.ExecuteWith(func(s string) (string, bool) {
+		v, ok := params[s]
+		return v, ok
+	}, ExecuteOptions{
+		Preserve: func(s string) bool {
+			return s != "PORT"
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "listen 8080; proxy_set_header Host $host; return ${host:-x}/${uri};"; got != want {
+		t.Errorf("Want output %q, got %q", want, got)
+	}
+}
+
+func TestExpandLiteralDollars(t *testing.T) {
+	tmpl, err := Parse("server $host; root $ROOT; $$x ${y:-$$}", WithBareVariables(), WithLiteralDollars())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := tmpl.ExecuteWith(func(s string) (string, bool) {
+		return "/srv", s == "ROOT"
+	}, ExecuteOptions{
+		Preserve: func(s string) bool {
+			return s != "ROOT"
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "server $host; root /srv; $$x ${y:-$$}"; got != want {
+		t.Errorf("Want output %q, got %q", want, got)
+	}
+}
+
+// TestPrintRoundTrip verifies that printing a parsed template, and
+// parsing the result, produces an equal tree. The inputs are the
+// expand test cases and random mutations of those cases.
+func TestPrintRoundTrip(t *testing.T) {
+	const chars = "${}#%/\\:-=?+^,()*! a1"
+	modes := []parse.Mode{0, parse.ParseBare, parse.ParseBare | parse.ParseArithmetic, parse.ParseBare | parse.ParseLiteralDollars}
+
+	var inputs []string
+	for _, expr := range expandTests {
+		inputs = append(inputs, expr.input)
+	}
+	inputs = append(inputs,
+		"$HOME/bin:$PATH $1$2 a$éb ${é}",
+		"$(( (${n:-1}+2) * $m ))$((x))",
+		`${a//\/\\/\/} $${a} $$a $ $`,
+	)
+
+	// mutate the inputs by inserting, deleting and replacing bytes.
+	r := rand.New(rand.NewSource(1))
+	for i, n := 0, len(inputs); i < 5000; i++ {
+		b := []byte(inputs[r.Intn(n)])
+		for j := r.Intn(4); j >= 0; j-- {
+			k := r.Intn(len(b) + 1)
+			c := chars[r.Intn(len(chars))]
+			switch r.Intn(3) {
+			case 0:
+				b = append(b[:k], append([]byte{c}, b[k:]...)...)
+			case 1:
+				if k < len(b) {
+					b = append(b[:k], b[k+1:]...)
+				}
+			case 2:
+				if k < len(b) {
+					b[k] = c
+				}
+			}
+		}
+		inputs = append(inputs, string(b))
+	}
+
+	for _, input := range inputs {
+		for _, mode := range modes {
+			tree, err := parse.ParseMode(input, mode)
+			if err != nil {
+				continue
+			}
+			text := parse.Print(tree)
+			got, err := parse.ParseMode(text, mode)
+			if err != nil {
+				t.Errorf("Want %q printed as %q parsed without error, got %v", input, text, err)
+				continue
+			}
+			if diff := cmp.Diff(tree.Root, got.Root, cmpopts.IgnoreTypes(parse.Position{})); diff != "" {
+				t.Errorf("Want %q printed as %q to round trip\n%s", input, text, diff)
+			}
+			if again := parse.Print(got); again != text {
+				t.Errorf("Want %q printed as %q, got %q", input, text, again)
+			}
+		}
+	}
+}
+
+// benchmark template, with a mix of text, parameters and functions.
+const benchText = "http://${HOST:-localhost}:${PORT}/${PATH##*/}?user=${USER,,}&v=${VERSION:0:3}"
+
+var benchParams = map[string]string{
+	"PORT":    "8080",
+	"PATH":    "/usr/local/bin",
+	"USER":    "Admin",
+	"VERSION": "1.2.3",
+}
+
+func benchMapping(s string) string {
+	return benchParams[s]
+}
+
+func BenchmarkEval(b *testing.B) {
+	if got, _ := Eval(benchText, benchMapping); got != "http://localhost:8080/bin?user=admin&v=1.2" {
+		b.Fatalf("Unexpected output %q", got)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		Eval(benchText, benchMapping)
+	}
+}
+
+// BenchmarkEvalParse parses the template for each evaluation, as
+// Eval did before parsed templates were cached.
+func BenchmarkEvalParse(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		tmpl, _ := Parse(benchText)
+		tmpl.Execute(benchMapping)
+	}
+}
+
+func BenchmarkEvalParallel(b *testing.B) {
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			Eval(benchText, benchMapping)
+		}
+	})
+}
+
+func BenchmarkExecute(b *testing.B) {
+	tmpl, err := Parse(benchText)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tmpl.Execute(benchMapping)
+	}
+}
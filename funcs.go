@@ -6,14 +6,19 @@ import (
 	"unicode"
 	"unicode/utf8"
 
-	"github.com/drone/envsubst/v2/path"
+	"github.com/drone/envsubst/v2/pattern"
 )
 
 // defines a parameter substitution function.
 type substituteFunc func(string, ...string) string
 
-// toLen returns the length of string s.
+// toLen returns the length of string s in characters.
 func toLen(s string, args ...string) string {
+	return strconv.Itoa(utf8.RuneCountInString(s))
+}
+
+// toByteLen returns the length of string s in bytes.
+func toByteLen(s string, args ...string) string {
 	return strconv.Itoa(len(s))
 }
 
@@ -49,34 +54,48 @@ func toUpperFirst(s string, args ...string) string {
 	return string(unicode.ToUpper(r)) + s[n:]
 }
 
-// toDefault returns a copy of the string s if not empty, else
-// returns a concatenation of the args without a separator.
-func toDefault(s string, args ...string) string {
-	if len(s) == 0 && len(args) > 0 {
-		// don't use any separator
-		s = strings.Join(args, "")
+// toSubstr returns a slice of the string s at the specified
+// length and position, counted in characters.
+func toSubstr(s string, args ...string) string {
+	i, j, ok := substrRange(utf8.RuneCountInString(s), args)
+	if !ok {
+		return s
 	}
-	return s
+	start := runeOffset(s, i)
+	end := start + runeOffset(s[start:], j-i)
+	return s[start:end]
 }
 
-// toSubstr returns a slice of the string s at the specified
-// length and position.
-func toSubstr(s string, args ...string) string {
+// toByteSubstr returns a slice of the string s at the specified
+// length and position, counted in bytes.
+func toByteSubstr(s string, args ...string) string {
+	i, j, ok := substrRange(len(s), args)
+	if !ok {
+		return s
+	}
+	return s[i:j]
+}
+
+// substrRange returns the start and end offsets of the substring
+// at the specified length and position, in a string of length n.
+// It reports false if the arguments cannot be parsed, in which case
+// the string is returned unchanged.
+func substrRange(n int, args []string) (start, end int, ok bool) {
 	if len(args) == 0 {
-		return s // should never happen
+		return 0, 0, false // should never happen
 	}
 
 	pos, err := strconv.Atoi(args[0])
 	if err != nil {
 		// bash returns the string if the position
 		// cannot be parsed.
-		return s
+		return 0, 0, false
 	}
 
 	if pos < 0 {
 		// if pos is negative (counts from the end) add it
 		// to length to get first character offset
-		pos = len(s) + pos
+		pos = n + pos
 
 		// if negative offset exceeds the length of the string
 		// start from 0
@@ -85,162 +104,215 @@ func toSubstr(s string, args ...string) string {
 		}
 	}
 
-	if len(args) == 1 {
-		if pos < len(s) {
-			return s[pos:]
-		}
+	if pos >= n {
 		// if the position exceeds the length of the
 		// string an empty string is returned
-		return ""
+		return n, n, true
+	}
+
+	if len(args) == 1 {
+		return pos, n, true
 	}
 
 	length, err := strconv.Atoi(args[1])
 	if err != nil {
 		// bash returns the string if the length
 		// cannot be parsed.
-		return s
+		return 0, 0, false
 	}
 
-	if pos+length >= len(s) {
-		if pos < len(s) {
-			// if the position exceeds the length of the
-			// string just return the rest of it like bash
-			return s[pos:]
+	if length < 0 {
+		// if length is negative (counts from the end) add it
+		// to length to get the end offset. bash fails if the
+		// end precedes the start, an empty string is returned.
+		if end := n + length; end > pos {
+			return pos, end, true
 		}
+		return pos, pos, true
+	}
+
+	if pos+length >= n {
 		// if the position exceeds the length of the
-		// string an empty string is returned
-		return ""
+		// string just return the rest of it like bash
+		return pos, n, true
 	}
 
-	return s[pos : pos+length]
+	return pos, pos + length, true
 }
 
-// replaceAll returns a copy of the string s with all instances
-// of the substring replaced with the replacement string.
-func replaceAll(s string, args ...string) string {
-	switch len(args) {
-	case 0:
+// replaceAll returns a copy of the string s with all matches
+// of the pattern replaced with the replacement string. Like bash,
+// an empty match is replaced and followed by the next character,
+// and an empty string that matches is replaced.
+func (m matcher) replaceAll(s string, args ...string) string {
+	pat, repl := replaceArgs(args)
+	if pat == "" {
 		return s
-	case 1:
-		return strings.Replace(s, args[0], "", -1)
-	default:
-		return strings.Replace(s, args[0], args[1], -1)
 	}
+	p := m.compile(pat)
+	if s == "" {
+		if p.Match("") {
+			return repl
+		}
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		j := matchLongest(p, s, i)
+		if j != -1 {
+			b.WriteString(repl)
+		}
+		if j > i {
+			i = j
+			continue
+		}
+		// copy the next character after an empty match, or no
+		// match, so that the loop advances.
+		_, n := utf8.DecodeRuneInString(s[i:])
+		b.WriteString(s[i : i+n])
+		i += n
+	}
+	return b.String()
 }
 
 // replaceFirst returns a copy of the string s with the first
-// instance of the substring replaced with the replacement string.
-func replaceFirst(s string, args ...string) string {
-	switch len(args) {
-	case 0:
+// match of the pattern replaced with the replacement string.
+// The first match may be empty, like in bash.
+func (m matcher) replaceFirst(s string, args ...string) string {
+	pat, repl := replaceArgs(args)
+	if pat == "" {
 		return s
-	case 1:
-		return strings.Replace(s, args[0], "", 1)
-	default:
-		return strings.Replace(s, args[0], args[1], 1)
 	}
+	p := m.compile(pat)
+	for i := 0; i <= len(s); {
+		if j := matchLongest(p, s, i); j != -1 {
+			return s[:i] + repl + s[j:]
+		}
+		if i == len(s) {
+			break
+		}
+		_, n := utf8.DecodeRuneInString(s[i:])
+		i += n
+	}
+	return s
 }
 
-// replacePrefix returns a copy of the string s with the matching
-// prefix replaced with the replacement string.
-func replacePrefix(s string, args ...string) string {
-	if len(args) != 2 {
+// replacePrefix returns a copy of the string s with the longest
+// prefix matching the pattern replaced with the replacement string.
+func (m matcher) replacePrefix(s string, args ...string) string {
+	if len(args) == 0 {
 		return s
 	}
-	if strings.HasPrefix(s, args[0]) {
-		return strings.Replace(s, args[0], args[1], 1)
+	pat, repl := replaceArgs(args)
+	if j := matchLongest(m.compile(pat), s, 0); j != -1 {
+		return repl + s[j:]
 	}
 	return s
 }
 
-// replaceSuffix returns a copy of the string s with the matching
-// suffix replaced with the replacement string.
-func replaceSuffix(s string, args ...string) string {
-	if len(args) != 2 {
+// replaceSuffix returns a copy of the string s with the longest
+// suffix matching the pattern replaced with the replacement string.
+func (m matcher) replaceSuffix(s string, args ...string) string {
+	if len(args) == 0 {
 		return s
 	}
-	if strings.HasSuffix(s, args[0]) {
-		s = strings.TrimSuffix(s, args[0])
-		s = s + args[1]
+	pat, repl := replaceArgs(args)
+	if i := m.compile(pat).LongestSuffix(s); i != -1 {
+		return s[:i] + repl
 	}
 	return s
 }
 
-// TODO
-
-func trimShortestPrefix(s string, args ...string) string {
-	if len(args) != 0 {
-		s = trimShortest(s, args[0])
+// replaceArgs returns the pattern and replacement arguments of
+// a replace function. The replacement defaults to empty.
+func replaceArgs(args []string) (pattern, repl string) {
+	switch len(args) {
+	case 0:
+		return "", ""
+	case 1:
+		return args[0], ""
+	default:
+		return args[0], args[1]
 	}
-	return s
 }
 
-func trimShortestSuffix(s string, args ...string) string {
+// trimShortestPrefix returns a copy of the string s with the
+// shortest prefix matching the pattern removed.
+func (m matcher) trimShortestPrefix(s string, args ...string) string {
 	if len(args) != 0 {
-		r := reverse(s)
-		rarg := reverse(args[0])
-		s = reverse(trimShortest(r, rarg))
+		if j := m.compile(args[0]).ShortestPrefix(s); j != -1 {
+			return s[j:]
+		}
 	}
 	return s
 }
 
-func trimLongestPrefix(s string, args ...string) string {
+// trimShortestSuffix returns a copy of the string s with the
+// shortest suffix matching the pattern removed.
+func (m matcher) trimShortestSuffix(s string, args ...string) string {
 	if len(args) != 0 {
-		s = trimLongest(s, args[0])
+		if i := m.compile(args[0]).ShortestSuffix(s); i != -1 {
+			return s[:i]
+		}
 	}
 	return s
 }
 
-func trimLongestSuffix(s string, args ...string) string {
+// trimLongestPrefix returns a copy of the string s with the
+// longest prefix matching the pattern removed.
+func (m matcher) trimLongestPrefix(s string, args ...string) string {
 	if len(args) != 0 {
-		r := reverse(s)
-		rarg := reverse(args[0])
-		s = reverse(trimLongest(r, rarg))
+		if j := matchLongest(m.compile(args[0]), s, 0); j != -1 {
+			return s[j:]
+		}
 	}
 	return s
 }
 
-func trimShortest(s, arg string) string {
-	var shortestMatch string
-	for i := 0; i < len(s); i++ {
-		match, err := path.Match(arg, s[0:len(s)-i])
-
-		if err != nil {
-			return s
-		}
-
-		if match {
-			shortestMatch = s[0 : len(s)-i]
+// trimLongestSuffix returns a copy of the string s with the
+// longest suffix matching the pattern removed.
+func (m matcher) trimLongestSuffix(s string, args ...string) string {
+	if len(args) != 0 {
+		if i := m.compile(args[0]).LongestSuffix(s); i != -1 {
+			return s[:i]
 		}
 	}
-
-	if shortestMatch != "" {
-		return strings.TrimPrefix(s, shortestMatch)
-	}
-
 	return s
 }
 
-func trimLongest(s, arg string) string {
-	for i := 0; i < len(s); i++ {
-		match, err := path.Match(arg, s[0:len(s)-i])
-
-		if err != nil {
-			return s
-		}
+// matcher implements the pattern matching functions using the
+// pattern syntax selected by mode.
+type matcher struct {
+	mode   pattern.Mode
+	static *pattern.Pattern // pattern compiled at parse time, if any
+}
 
-		if match {
-			return strings.TrimPrefix(s, s[0:len(s)-i])
-		}
+// compile returns the compiled pattern.
+func (m matcher) compile(pat string) *pattern.Pattern {
+	if m.static != nil {
+		return m.static
 	}
+	p, _ := pattern.CompileMode(pat, m.mode)
+	return p
+}
 
-	return s
+// matchLongest returns the end offset of the longest match of the
+// pattern in s that starts at offset i, or -1 if there is no match.
+func matchLongest(p *pattern.Pattern, s string, i int) int {
+	if j := p.LongestPrefix(s[i:]); j != -1 {
+		return i + j
+	}
+	return -1
 }
 
-func reverse(s string) string {
-	r := []rune(s)
-	for i, j := 0, len(r)-1; i < len(r)/2; i, j = i+1, j-1 {
-		r[i], r[j] = r[j], r[i]
+// runeOffset returns the byte offset of the nth character in s,
+// or the length of s if it has fewer characters.
+func runeOffset(s string, n int) int {
+	for i := range s {
+		if n == 0 {
+			return i
+		}
+		n--
 	}
-	return string(r)
+	return len(s)
 }
@@ -1,12 +1,30 @@
 package envsubst
 
-import "testing"
+import (
+	"math/rand"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/drone/envsubst/v2/pattern"
+)
 
 func Test_len(t *testing.T) {
 	got, want := toLen("Hello World"), "11"
 	if got != want {
 		t.Errorf("Expect len function to return %s, got %s", want, got)
 	}
+
+	got, want = toLen("Zoë"), "3"
+	if got != want {
+		t.Errorf("Expect len function to count characters, got %s", got)
+	}
+
+	got, want = toByteLen("Zoë"), "4"
+	if got != want {
+		t.Errorf("Expect byte len function to count bytes, got %s", got)
+	}
 }
 
 func Test_lower(t *testing.T) {
@@ -49,23 +67,6 @@ func Test_upperFirst(t *testing.T) {
 	toUpperFirst("")
 }
 
-func Test_default(t *testing.T) {
-	got, want := toDefault("Hello World", "Hola Mundo"), "Hello World"
-	if got != want {
-		t.Errorf("Expect default function uses variable value")
-	}
-
-	got, want = toDefault("", "Hola Mundo"), "Hola Mundo"
-	if got != want {
-		t.Errorf("Expect default function uses default value, when variable empty. Got %s, Want %s", got, want)
-	}
-
-	got, want = toDefault("", "Hola Mundo", "-Bonjour le monde", "-Halló heimur"), "Hola Mundo-Bonjour le monde-Halló heimur"
-	if got != want {
-		t.Errorf("Expect default function to use concatenated args when variable empty. Got %s, Want %s", got, want)
-	}
-}
-
 func Test_substr(t *testing.T) {
 	got, want := toSubstr("123456789123456789", "0", "8"), "12345678"
 	if got != want {
@@ -101,4 +102,173 @@ func Test_substr(t *testing.T) {
 	if got != want {
 		t.Errorf("Expect substr function to cut entire string if pos is itself out of bound")
 	}
+
+	got, want = toSubstr("Zoëé", "2"), "ëé"
+	if got != want {
+		t.Errorf("Expect substr function to count characters, got %q", got)
+	}
+
+	got, want = toSubstr("Zoëé", "-2", "1"), "ë"
+	if got != want {
+		t.Errorf("Expect substr function to count negative offsets in characters, got %q", got)
+	}
+
+	got, want = toByteSubstr("Zoëé", "3", "1"), "\xab"
+	if got != want {
+		t.Errorf("Expect byte substr function to count bytes, got %q", got)
+	}
+}
+
+func Test_replace(t *testing.T) {
+	var tests = []struct {
+		fn   substituteFunc
+		args []string
+		want string
+	}{
+		{matcher{}.replaceFirst, []string{"a*c", "x"}, "xABC"},
+		{matcher{}.replaceFirst, []string{""}, "abcABC"},
+		{matcher{}.replaceAll, []string{"[a-z]", "-"}, "---ABC"},
+		{matcher{}.replaceAll, []string{"[a-z]"}, "ABC"},
+		{matcher{}.replaceAll, []string{"*", "x"}, "x"},
+		{matcher{mode: pattern.ExtGlob}.replaceAll, []string{"*([a-z])", "x"}, "xxAxBxC"},
+		{matcher{mode: pattern.ExtGlob}.replaceFirst, []string{"?(A)", "x"}, "xabcABC"},
+		{matcher{}.replacePrefix, []string{"*b", "x"}, "xcABC"},
+		{matcher{}.replacePrefix, []string{"b*", "x"}, "abcABC"},
+		{matcher{}.replaceSuffix, []string{"[A-Z]*", "x"}, "abcx"},
+		{matcher{}.replaceSuffix, []string{"[", "x"}, "abcABC"},
+	}
+	for _, test := range tests {
+		if got := test.fn("abcABC", test.args...); got != test.want {
+			t.Errorf("Expect replace function with %q to return %s, got %s", test.args, test.want, got)
+		}
+	}
+}
+
+// Test_patternBash compares the trim and replace functions to bash
+// for random patterns and strings. It is skipped if bash is not
+// installed.
+func Test_patternBash(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash not installed")
+	}
+	tokens := []string{"a", "b", "é", "-", "?", "*", "[ab]", "[!a]", "[a-b]", "\\*"}
+	// !(pattern-list) is not compared, since bash does not match it
+	// correctly when it is followed by other patterns:
+	//
+	//	$ s=''; [[ $s == *!(b)a ]] && echo match
+	//	match
+	extTokens := []string{"@(a|b)", "*(a)", "+(ab|b)", "?(é)"}
+	chars := []string{"a", "b", "é", "-", "*"}
+
+	for _, mode := range []pattern.Mode{0, pattern.ExtGlob} {
+		toks := tokens
+		if mode == pattern.ExtGlob {
+			toks = append(toks, extTokens...)
+		}
+
+		r := rand.New(rand.NewSource(1))
+		type testCase struct{ s, pat string }
+		var cases []testCase
+		for i := 0; i < 200; i++ {
+			var c testCase
+			for j := r.Intn(3); j >= 0; j-- {
+				c.pat += toks[r.Intn(len(toks))]
+			}
+			for j := r.Intn(10); j > 0; j-- {
+				c.s += chars[r.Intn(len(chars))]
+			}
+			cases = append(cases, c)
+		}
+
+		// the script prints the results of each case terminated
+		// by a null byte.
+		var script strings.Builder
+		for _, c := range cases {
+			script.WriteString("s='" + c.s + "'; p='" + c.pat + "'; ")
+			script.WriteString(`printf '%s\0' "${s#$p}" "${s##$p}" "${s%$p}" "${s%%$p}" "${s/$p/x}" "${s//$p/x}" "${s/#$p/x}" "${s/%$p/x}"` + "\n")
+		}
+		args := []string{"-c", script.String()}
+		if mode == pattern.ExtGlob {
+			args = append([]string{"-O", "extglob"}, args...)
+		}
+		cmd := exec.Command("bash", args...)
+		cmd.Env = append(os.Environ(), "LC_ALL=C.UTF-8")
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := strings.Split(string(out), "\x00")
+
+		m := matcher{mode: mode}
+		for i, c := range cases {
+			got := []string{
+				m.trimShortestPrefix(c.s, c.pat),
+				m.trimLongestPrefix(c.s, c.pat),
+				m.trimShortestSuffix(c.s, c.pat),
+				m.trimLongestSuffix(c.s, c.pat),
+				m.replaceFirst(c.s, c.pat, "x"),
+				m.replaceAll(c.s, c.pat, "x"),
+				m.replacePrefix(c.s, c.pat, "x"),
+				m.replaceSuffix(c.s, c.pat, "x"),
+			}
+			for j, op := range []string{"#", "##", "%", "%%", "/", "//", "/#", "/%"} {
+				if bashDiffers(m, op, c.pat, c.s) {
+					continue
+				}
+				if w := want[i*len(got)+j]; got[j] != w {
+					t.Errorf("Want ${s%s%s} of %q to be %q like bash, got %q", op, c.pat, c.s, w, got[j])
+				}
+			}
+		}
+	}
+}
+
+// bashDiffers reports whether the result of the operator is known to
+// differ from bash 5.2, because of quirks in the way bash matches the
+// ${s/pattern}, ${s//pattern} and ${s/#pattern} operators.
+func bashDiffers(m matcher, op, pat, s string) bool {
+	if op != "/" && op != "//" && op != "/#" {
+		return false
+	}
+
+	// bash first checks that the value matches the pattern enclosed in
+	// stars, but does not add the stars if the pattern starts with a
+	// star and ends with an escaped star, so nothing matches unless
+	// the whole value matches, although ${s##pattern} matches:
+	//
+	//	$ s='-a*b'; p='*a\*'; echo "${s/$p/x} ${s##$p}"
+	//	-a*b b
+	if strings.HasPrefix(pat, "*") && strings.HasSuffix(pat, "\\*") && !m.compile(pat).Match(s) {
+		return true
+	}
+
+	// bash checks that the first character of the value can start a
+	// match, which fails for the empty value and ?(), @() and +()
+	// patterns that match it, although [[ == ]] matches:
+	//
+	//	$ s=''; p='?(a)'; [[ $s == $p ]] && echo "[${s/$p/x}] [${s/%$p/x}]"
+	//	[] [x]
+	if s == "" {
+		for _, prefix := range []string{"?(", "@(", "+("} {
+			if strings.HasPrefix(pat, prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func BenchmarkTrimLongValue(b *testing.B) {
+	s := strings.Repeat("abcdefgh/", 1024)
+	m := matcher{}
+	for _, pat := range []string{"*/", "*x"} {
+		b.Run(pat, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				m.trimShortestPrefix(s, pat)
+				m.trimLongestPrefix(s, pat)
+				m.trimShortestSuffix(s, pat)
+				m.trimLongestSuffix(s, pat)
+			}
+		})
+	}
 }
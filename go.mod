@@ -1,5 +1,8 @@
 module github.com/drone/envsubst/v2
 
-require github.com/google/go-cmp v0.2.0
+require (
+	github.com/google/go-cmp v0.2.0
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 go 1.13
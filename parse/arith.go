@@ -0,0 +1,430 @@
+package parse
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrArithSyntax represents a syntax error in an arithmetic
+	// expression.
+	ErrArithSyntax = errors.New("syntax error in arithmetic expression")
+
+	// ErrDivisionByZero represents a division or remainder by zero in
+	// an arithmetic expression.
+	ErrDivisionByZero = errors.New("division by 0")
+
+	// ErrNegativeExponent represents a negative exponent in an
+	// arithmetic expression.
+	ErrNegativeExponent = errors.New("exponent less than 0")
+
+	// ErrArithRecursion represents the error when variable values
+	// referenced by an arithmetic expression are nested too deeply.
+	ErrArithRecursion = errors.New("expression recursion level exceeded")
+)
+
+// maxArithDepth is the maximum depth of variable values evaluated
+// as arithmetic expressions, similar to bash.
+const maxArithDepth = 1024
+
+// Expr is an element in an arithmetic expression.
+type Expr interface {
+	expr()
+}
+
+// an arithmetic expression is represented by a tree consisting of
+// one or more of the following expressions.
+type (
+	// NumberExpr represents an integer constant.
+	NumberExpr struct {
+		Text  string // constant text, such as 42 or 0x2a
+		Value int64
+	}
+
+	// NameExpr represents a variable reference. The variable value
+	// is evaluated as an arithmetic expression, where a variable
+	// that is unset or empty evaluates to 0.
+	NameExpr struct {
+		Name string
+	}
+
+	// UnaryExpr represents a unary operation, such as -x or !x.
+	UnaryExpr struct {
+		Op string
+		X  Expr
+	}
+
+	// BinaryExpr represents a binary operation, such as x+y or x<=y.
+	BinaryExpr struct {
+		Op string
+		X  Expr
+		Y  Expr
+	}
+
+	// ParenExpr represents a parenthesized expression.
+	ParenExpr struct {
+		X Expr
+	}
+)
+
+func (*NumberExpr) expr() {}
+func (*NameExpr) expr()   {}
+func (*UnaryExpr) expr()  {}
+func (*BinaryExpr) expr() {}
+func (*ParenExpr) expr()  {}
+
+// binary operators grouped by precedence, from lowest to highest.
+var binaryOps = [][]string{
+	{"||"},
+	{"&&"},
+	{"|"},
+	{"^"},
+	{"&"},
+	{"==", "!="},
+	{"<=", ">=", "<", ">"},
+	{"<<", ">>"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+// operators sorted so that longer operators are matched first.
+var arithOps = []string{
+	"**", "||", "&&", "==", "!=", "<=", ">=", "<<", ">>",
+	"|", "^", "&", "<", ">", "+", "-", "*", "/", "%", "!", "~", "(", ")",
+}
+
+// ParseArith parses the arithmetic expression in string s, such as
+// the contents of $((expr)) or the offset of ${var:offset}. The
+// expression supports integer constants, variable names, parentheses
+// and the bash unary, binary and comparison operators. An empty
+// expression evaluates to 0.
+func ParseArith(s string) (Expr, error) {
+	p := &arithParser{src: s}
+	p.next()
+	if p.tok == "" {
+		return &NumberExpr{Text: "0"}, nil
+	}
+	x, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.tok != "" {
+		return nil, p.errorf()
+	}
+	return x, nil
+}
+
+// EvalArith evaluates the arithmetic expression using the lookup
+// function to resolve variable names.
+func EvalArith(x Expr, lookup func(string) (string, bool)) (int64, error) {
+	e := &arithEvaluator{lookup: lookup}
+	return e.eval(x)
+}
+
+// arithParser implements a precedence climbing parser for
+// arithmetic expressions.
+type arithParser struct {
+	src  string
+	pos  int    // byte offset following the current token
+	tok  string // current token, or empty at the end
+	kind byte   // current token kind: 'n' number, 'a' name, 'o' operator
+	off  int    // byte offset of the current token
+}
+
+// next advances to the next token.
+func (p *arithParser) next() {
+	for p.pos < len(p.src) && isArithSpace(p.src[p.pos]) {
+		p.pos++
+	}
+	p.off = p.pos
+	p.tok, p.kind = "", 0
+	if p.pos >= len(p.src) {
+		return
+	}
+	c := p.src[p.pos]
+	switch {
+	case isArithDigit(c):
+		p.kind = 'n'
+		for p.pos < len(p.src) && isArithNameChar(p.src[p.pos]) {
+			p.pos++
+		}
+	case isArithNameStart(c):
+		p.kind = 'a'
+		for p.pos < len(p.src) && isArithNameChar(p.src[p.pos]) {
+			p.pos++
+		}
+	default:
+		p.kind = 'o'
+		p.pos++
+		for _, op := range arithOps {
+			if strings.HasPrefix(p.src[p.off:], op) {
+				p.pos = p.off + len(op)
+				break
+			}
+		}
+	}
+	p.tok = p.src[p.off:p.pos]
+}
+
+// parseBinary parses a binary expression with operators of at least
+// the precedence level.
+func (p *arithParser) parseBinary(level int) (Expr, error) {
+	if level == len(binaryOps) {
+		return p.parsePower()
+	}
+	x, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for p.kind == 'o' && hasOp(binaryOps[level], p.tok) {
+		op := p.tok
+		p.next()
+		y, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		x = &BinaryExpr{Op: op, X: x, Y: y}
+	}
+	return x, nil
+}
+
+// parsePower parses the right associative exponentiation operator.
+func (p *arithParser) parsePower() (Expr, error) {
+	x, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	if p.kind == 'o' && p.tok == "**" {
+		p.next()
+		y, err := p.parsePower()
+		if err != nil {
+			return nil, err
+		}
+		x = &BinaryExpr{Op: "**", X: x, Y: y}
+	}
+	return x, nil
+}
+
+// parseUnary parses a unary expression or an operand.
+func (p *arithParser) parseUnary() (Expr, error) {
+	switch p.kind {
+	case 'n':
+		x, err := parseNumber(p.tok)
+		if err != nil {
+			return nil, p.errorf()
+		}
+		p.next()
+		return x, nil
+	case 'a':
+		x := &NameExpr{Name: p.tok}
+		p.next()
+		return x, nil
+	case 'o':
+		switch p.tok {
+		case "+", "-", "!", "~":
+			op := p.tok
+			p.next()
+			x, err := p.parseUnary()
+			if err != nil {
+				return nil, err
+			}
+			return &UnaryExpr{Op: op, X: x}, nil
+		case "(":
+			p.next()
+			x, err := p.parseBinary(0)
+			if err != nil {
+				return nil, err
+			}
+			if p.tok != ")" {
+				return nil, p.errorf()
+			}
+			p.next()
+			return &ParenExpr{X: x}, nil
+		}
+	}
+	return nil, p.errorf()
+}
+
+// errorf returns a syntax error for the current token.
+func (p *arithParser) errorf() error {
+	return newError(ErrArithSyntax, p.src, p.off, len(p.src))
+}
+
+// parseNumber parses an integer constant. Constants with a leading
+// 0x are hexadecimal and constants with a leading 0 are octal.
+func parseNumber(s string) (*NumberExpr, error) {
+	base, digits := 10, s
+	switch {
+	case strings.HasPrefix(s, "0x"), strings.HasPrefix(s, "0X"):
+		base, digits = 16, s[2:]
+	case len(s) > 1 && s[0] == '0':
+		base, digits = 8, s[1:]
+	}
+	v, err := strconv.ParseUint(digits, base, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &NumberExpr{Text: s, Value: int64(v)}, nil
+}
+
+// arithEvaluator evaluates arithmetic expressions.
+type arithEvaluator struct {
+	lookup func(string) (string, bool)
+	depth  int
+}
+
+func (e *arithEvaluator) eval(x Expr) (int64, error) {
+	switch x := x.(type) {
+	case *NumberExpr:
+		return x.Value, nil
+	case *NameExpr:
+		return e.evalName(x.Name)
+	case *ParenExpr:
+		return e.eval(x.X)
+	case *UnaryExpr:
+		v, err := e.eval(x.X)
+		if err != nil {
+			return 0, err
+		}
+		switch x.Op {
+		case "-":
+			return -v, nil
+		case "!":
+			return boolToInt(v == 0), nil
+		case "~":
+			return ^v, nil
+		}
+		return v, nil
+	case *BinaryExpr:
+		return e.evalBinary(x)
+	}
+	return 0, ErrArithSyntax
+}
+
+// evalName evaluates the value of the named variable as an
+// arithmetic expression.
+func (e *arithEvaluator) evalName(name string) (int64, error) {
+	v, _ := e.lookup(name)
+	if strings.TrimSpace(v) == "" {
+		return 0, nil
+	}
+	if e.depth == maxArithDepth {
+		return 0, ErrArithRecursion
+	}
+	x, err := ParseArith(v)
+	if err != nil {
+		return 0, ErrArithSyntax
+	}
+	e.depth++
+	n, err := e.eval(x)
+	e.depth--
+	return n, err
+}
+
+func (e *arithEvaluator) evalBinary(x *BinaryExpr) (int64, error) {
+	a, err := e.eval(x.X)
+	if err != nil {
+		return 0, err
+	}
+
+	// the right operand of the logical operators is only
+	// evaluated when it determines the result.
+	switch {
+	case x.Op == "&&" && a == 0:
+		return 0, nil
+	case x.Op == "||" && a != 0:
+		return 1, nil
+	}
+
+	b, err := e.eval(x.Y)
+	if err != nil {
+		return 0, err
+	}
+
+	switch x.Op {
+	case "&&", "||":
+		return boolToInt(b != 0), nil
+	case "|":
+		return a | b, nil
+	case "^":
+		return a ^ b, nil
+	case "&":
+		return a & b, nil
+	case "==":
+		return boolToInt(a == b), nil
+	case "!=":
+		return boolToInt(a != b), nil
+	case "<=":
+		return boolToInt(a <= b), nil
+	case ">=":
+		return boolToInt(a >= b), nil
+	case "<":
+		return boolToInt(a < b), nil
+	case ">":
+		return boolToInt(a > b), nil
+	case "<<":
+		return a << uint64(b&63), nil
+	case ">>":
+		return a >> uint64(b&63), nil
+	case "+":
+		return a + b, nil
+	case "-":
+		return a - b, nil
+	case "*":
+		return a * b, nil
+	case "/", "%":
+		if b == 0 {
+			return 0, ErrDivisionByZero
+		}
+		if x.Op == "/" {
+			return a / b, nil
+		}
+		return a % b, nil
+	case "**":
+		if b < 0 {
+			return 0, ErrNegativeExponent
+		}
+		n := int64(1)
+		for ; b > 0; b >>= 1 {
+			if b&1 != 0 {
+				n *= a
+			}
+			a *= a
+		}
+		return n, nil
+	}
+	return 0, ErrArithSyntax
+}
+
+func hasOp(ops []string, op string) bool {
+	for _, o := range ops {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+func boolToInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func isArithSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func isArithDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isArithNameStart(c byte) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+func isArithNameChar(c byte) bool {
+	return isArithNameStart(c) || isArithDigit(c)
+}
@@ -0,0 +1,134 @@
+package parse
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestEvalArith(t *testing.T) {
+	vars := map[string]string{
+		"x": "4",
+		"y": "x+1",
+		"z": "",
+	}
+	lookup := func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+
+	// expected values verified with bash
+	var tests = []struct {
+		expr  string
+		value int64
+	}{
+		{"", 0},
+		{" 7 ", 7},
+		{"1+2*3", 7},
+		{"(1+2)*3", 9},
+		{"1-2-3", -4},
+		{"10/3", 3},
+		{"-10/3", -3},
+		{"-10%3", -1},
+		{"2**10", 1024},
+		{"-2**2", 4},
+		{"2**3**2", 512},
+		{"2*-3", -6},
+		{"- -1", 1},
+		{"+4", 4},
+		{"1<2", 1},
+		{"2<=1", 0},
+		{"3==3", 1},
+		{"3!=3", 0},
+		{"1 < 2 == 1", 1},
+		{"5 & 3 == 3", 1},
+		{"!0", 1},
+		{"!1+1", 1},
+		{"~5", -6},
+		{"1<<4", 16},
+		{"256>>2", 64},
+		{"6&3", 2},
+		{"6|3", 7},
+		{"6^3", 5},
+		{"1&&0", 0},
+		{"0||2", 1},
+		{"010", 8},
+		{"0x1F", 31},
+		{"x*2", 8},
+		{"y+1", 6},
+		{"x+y*2", 14},
+		{"z", 0},
+		{"unset", 0},
+		{"0&&1/0", 0},
+		{"1||1/0", 1},
+	}
+
+	for _, test := range tests {
+		x, err := ParseArith(test.expr)
+		if err != nil {
+			t.Errorf("Unexpected error parsing %q: %s", test.expr, err)
+			continue
+		}
+		got, err := EvalArith(x, lookup)
+		if err != nil {
+			t.Errorf("Unexpected error evaluating %q: %s", test.expr, err)
+			continue
+		}
+		if got != test.value {
+			t.Errorf("Want %q evaluated to %d, got %d", test.expr, test.value, got)
+		}
+	}
+}
+
+func TestEvalArithError(t *testing.T) {
+	vars := map[string]string{
+		"a": "b",
+		"b": "a",
+		"c": "1+",
+	}
+	lookup := func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+
+	var tests = []struct {
+		expr string
+		err  error
+	}{
+		{"1+", ErrArithSyntax},
+		{"(1", ErrArithSyntax},
+		{"1)", ErrArithSyntax},
+		{"1 2", ErrArithSyntax},
+		{"08", ErrArithSyntax},
+		{"$x", ErrArithSyntax},
+		{"x=1", ErrArithSyntax},
+		{"1/0", ErrDivisionByZero},
+		{"1%0", ErrDivisionByZero},
+		{"2**-1", ErrNegativeExponent},
+		{"a", ErrArithRecursion},
+		{"c*2", ErrArithSyntax},
+	}
+
+	for _, test := range tests {
+		x, err := ParseArith(test.expr)
+		if err == nil {
+			_, err = EvalArith(x, lookup)
+		}
+		if !errors.Is(err, test.err) {
+			t.Errorf("Want error %q for %q, got %v", test.err, test.expr, err)
+		}
+	}
+}
+
+func TestParseArithError(t *testing.T) {
+	_, err := ParseArith("1 + * 2")
+	perr, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("Want parse error, got %v", err)
+	}
+	if got, want := perr.Offset, 4; got != want {
+		t.Errorf("Want error offset %d, got %d", want, got)
+	}
+	if got, want := perr.Expr, "* 2"; got != want {
+		t.Errorf("Want error expression %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,71 @@
+package parse
+
+// Copy returns a deep copy of the tree. Changes to the nodes of the
+// copy do not affect the original tree.
+func (t *Tree) Copy() *Tree {
+	if t == nil {
+		return nil
+	}
+	return &Tree{Root: copyNode(t.Root), Mode: t.Mode}
+}
+
+// copyNode returns a deep copy of the node.
+func copyNode(node Node) Node {
+	switch n := node.(type) {
+	case nil:
+		return nil
+	case *TextNode:
+		c := *n
+		return &c
+	case *ListNode:
+		c := &ListNode{Nodes: make([]Node, len(n.Nodes))}
+		for i, child := range n.Nodes {
+			c.Nodes[i] = copyNode(child)
+		}
+		return c
+	case *ParamNode:
+		c := *n
+		return &c
+	case *LenNode:
+		c := *n
+		return &c
+	case *CaseNode:
+		c := *n
+		return &c
+	case *SubstrNode:
+		c := *n
+		c.Offset = copyNode(n.Offset)
+		c.Length = copyNode(n.Length)
+		return &c
+	case *TrimNode:
+		c := *n
+		c.Pattern = copyNode(n.Pattern)
+		return &c
+	case *ReplaceNode:
+		c := *n
+		c.Pattern = copyNode(n.Pattern)
+		c.Replacement = copyNode(n.Replacement)
+		return &c
+	case *DefaultNode:
+		c := *n
+		c.Value = copyNode(n.Value)
+		return &c
+	case *RequiredNode:
+		c := *n
+		c.Message = copyNode(n.Message)
+		return &c
+	case *AlternateNode:
+		c := *n
+		c.Value = copyNode(n.Value)
+		return &c
+	case *PipeNode:
+		c := *n
+		c.Funcs = append([]string(nil), n.Funcs...)
+		return &c
+	case *ArithNode:
+		c := *n
+		c.Expr = copyNode(n.Expr)
+		return &c
+	}
+	panic("parse: unknown node type")
+}
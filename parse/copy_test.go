@@ -0,0 +1,39 @@
+package parse
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCopy(t *testing.T) {
+	text := "a ${b} ${#c} ${d^^} ${e:1:${f}} ${g##*/} ${h//x/${i}} " +
+		"${j:-k} ${l:?m} ${n:+o} ${p|q|r} $((${s}+1))"
+	tree, err := ParseFuncs(text, ParseArithmetic, map[string]bool{"q": true, "r": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := tree.Copy()
+	if diff := cmp.Diff(tree.Root, c.Root); diff != "" {
+		t.Errorf("Want copy equal to the tree:\n%s", diff)
+	}
+	if c.Mode != tree.Mode {
+		t.Errorf("Want copy mode %v, got %v", tree.Mode, c.Mode)
+	}
+
+	// changes to the copy do not affect the tree.
+	Inspect(c.Root, func(node Node) bool {
+		switch n := node.(type) {
+		case *TextNode:
+			n.Value = "x"
+		case *ParamNode:
+			n.Name = "x"
+		case *PipeNode:
+			n.Funcs[0] = "x"
+		}
+		return true
+	})
+	if got := Print(tree); got != text {
+		t.Errorf("Want tree printed as %q after changing the copy, got %q", text, got)
+	}
+}
@@ -0,0 +1,55 @@
+package parse
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Error represents a parse error at a position in the source. The
+// underlying error is one of the package sentinel errors, such as
+// ErrMissingClosingBrace, and can be tested with errors.Is.
+type Error struct {
+	Err      error  // underlying error
+	Filename string // source file name, if any
+	Offset   int    // byte offset, starting at 0
+	Line     int    // line number, starting at 1
+	Column   int    // byte column number, starting at 1
+	Expr     string // offending expression
+}
+
+func (e *Error) Error() string {
+	if e.Filename == "" {
+		return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Err)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", e.Filename, e.Line, e.Column, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// newError returns a new Error for the expression in the source
+// starting at byte offset start and ending at byte offset end. The
+// expression is truncated at the end of the line.
+func newError(err error, source string, start, end int) *Error {
+	if end > len(source) {
+		end = len(source)
+	}
+	if end < start {
+		end = start
+	}
+	expr := source[start:end]
+	if i := strings.IndexByte(expr, '\n'); i != -1 {
+		expr = expr[:i]
+	}
+	line := 1 + strings.Count(source[:start], "\n")
+	column := 1 + start - (strings.LastIndexByte(source[:start], '\n') + 1)
+	return &Error{
+		Err:    err,
+		Offset: start,
+		Line:   line,
+		Column: column,
+		Expr:   expr,
+	}
+}
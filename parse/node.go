@@ -2,12 +2,20 @@ package parse
 
 // Node is an element in the parse tree.
 type Node interface {
+	// String returns the template text of the node.
+	String() string
 	node()
 }
 
 // empty string node
 var empty = new(TextNode)
 
+// Position records the source position of a node.
+type Position struct {
+	Pos int // byte offset of the node in the source
+	End int // byte offset immediately after the node
+}
+
 // a template is represented by a tree consisting of one
 // or more of the following nodes.
 type (
@@ -16,52 +24,108 @@ type (
 		Value string
 	}
 
-	// FuncNode represents a string function.
-	FuncNode struct {
-		Param string
-		Name  string
-		Args  []Node
-	}
-
 	// ListNode represents a list of nodes.
 	ListNode struct {
 		Nodes []Node
 	}
 
-	// ParamNode struct{
-	// 	Name string
-	// }
-	//
-	// CaseNode struct {
-	// 	Name string
-	// 	First bool
-	// }
-	//
-	// LowerNode struct {
-	// 	Name string
-	// 	First bool
-	// }
-	//
-	// SubstrNode struct {
-	// 	Name string
-	// 	Pos Node
-	// 	Len Node
-	// }
-	//
-	// ReplaceNode struct {
-	// 	Name string
-	// 	Substring Node
-	// 	Replacement Node
-	// }
-	//
-	// TrimNode struct{
-	//
-	// }
-	//
-	// DefaultNode struct {
-	// 	Name string
-	// 	Default Node
-	// }
+	// ParamNode represents the ${name} parameter, or the unbraced
+	// $name parameter.
+	ParamNode struct {
+		Position
+		Name string
+		Bare bool // unbraced reference
+	}
+
+	// LenNode represents the ${#name} function.
+	LenNode struct {
+		Position
+		Name string
+	}
+
+	// CaseNode represents the ${name^}, ${name^^}, ${name,} and
+	// ${name,,} functions.
+	CaseNode struct {
+		Position
+		Name  string
+		Upper bool // convert to upper case
+		First bool // convert the first character only
+	}
+
+	// SubstrNode represents the ${name:offset} and
+	// ${name:offset:length} functions.
+	SubstrNode struct {
+		Position
+		Name   string
+		Offset Node
+		Length Node // nil if omitted
+	}
+
+	// TrimNode represents the ${name#pattern}, ${name##pattern},
+	// ${name%pattern} and ${name%%pattern} functions.
+	TrimNode struct {
+		Position
+		Name    string
+		Pattern Node
+		Suffix  bool // trim from the end
+		Longest bool // trim the longest match
+	}
+
+	// ReplaceNode represents the ${name/pattern/replacement},
+	// ${name//pattern/replacement}, ${name/#pattern/replacement}
+	// and ${name/%pattern/replacement} functions.
+	ReplaceNode struct {
+		Position
+		Name        string
+		Pattern     Node
+		Replacement Node
+		All         bool // replace all matches
+		Prefix      bool // match at the start
+		Suffix      bool // match at the end
+	}
+
+	// DefaultNode represents the ${name-word}, ${name:-word},
+	// ${name=word} and ${name:=word} functions.
+	DefaultNode struct {
+		Position
+		Name   string
+		Value  Node
+		Colon  bool // empty values are treated as unset
+		Assign bool // assign the default value
+	}
+
+	// RequiredNode represents the ${name?message} and
+	// ${name:?message} functions.
+	RequiredNode struct {
+		Position
+		Name    string
+		Message Node
+		Colon   bool // empty values are treated as unset
+	}
+
+	// AlternateNode represents the ${name+word} and ${name:+word}
+	// functions.
+	AlternateNode struct {
+		Position
+		Name  string
+		Value Node
+		Colon bool // empty values are treated as unset
+	}
+
+	// PipeNode represents the ${name|func} function, which passes
+	// the value through each of the named functions in order, such
+	// as ${name|trim|base64}.
+	PipeNode struct {
+		Position
+		Name  string
+		Funcs []string
+	}
+
+	// ArithNode represents an arithmetic expansion $((expr)).
+	ArithNode struct {
+		Position
+		Expr Node // expression text, which may contain substitutions
+	}
 )
 
 // newTextNode returns a new TextNode.
@@ -74,13 +138,40 @@ func newListNode(nodes ...Node) *ListNode {
 	return &ListNode{Nodes: nodes}
 }
 
-// newFuncNode returns a new FuncNode.
-func newFuncNode(name string) *FuncNode {
-	return &FuncNode{Param: name}
+// setPosition sets the source position of the node.
+func (p *Position) setPosition(pos, end int) {
+	p.Pos = pos
+	p.End = end
 }
 
 // node() defines the node in a parse tree
 
-func (*TextNode) node() {}
-func (*ListNode) node() {}
-func (*FuncNode) node() {}
+func (*TextNode) node()      {}
+func (*ListNode) node()      {}
+func (*ParamNode) node()     {}
+func (*LenNode) node()       {}
+func (*CaseNode) node()      {}
+func (*SubstrNode) node()    {}
+func (*TrimNode) node()      {}
+func (*ReplaceNode) node()   {}
+func (*DefaultNode) node()   {}
+func (*RequiredNode) node()  {}
+func (*AlternateNode) node() {}
+func (*PipeNode) node()      {}
+func (*ArithNode) node()     {}
+
+// String returns the template source of the node.
+
+func (n *TextNode) String() string      { return printNode(n) }
+func (n *ListNode) String() string      { return printNode(n) }
+func (n *ParamNode) String() string     { return printNode(n) }
+func (n *LenNode) String() string       { return printNode(n) }
+func (n *CaseNode) String() string      { return printNode(n) }
+func (n *SubstrNode) String() string    { return printNode(n) }
+func (n *TrimNode) String() string      { return printNode(n) }
+func (n *ReplaceNode) String() string   { return printNode(n) }
+func (n *DefaultNode) String() string   { return printNode(n) }
+func (n *RequiredNode) String() string  { return printNode(n) }
+func (n *AlternateNode) String() string { return printNode(n) }
+func (n *PipeNode) String() string      { return printNode(n) }
+func (n *ArithNode) String() string     { return printNode(n) }
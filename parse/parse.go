@@ -2,6 +2,7 @@ package parse
 
 import (
 	"errors"
+	"fmt"
 )
 
 var (
@@ -22,26 +23,71 @@ var (
 	// ErrParseDefaultFunction represent the error when unable to parse a
 	// default function.
 	ErrParseDefaultFunction = errors.New("unable to parse default function")
+
+	// ErrMissingClosingParen represents a missing closing "))" error
+	// in an arithmetic expansion.
+	ErrMissingClosingParen = errors.New("missing closing parenthesis")
+
+	// ErrUnknownFunction represents the error when a pipe function,
+	// such as ${var|func}, is not defined.
+	ErrUnknownFunction = errors.New("unknown function")
+)
+
+// Mode values are a set of flags (or 0) that control parser behavior.
+type Mode uint
+
+const (
+	// ParseBare enables parsing of unbraced variable references such
+	// as $var and $1. By default these are treated as literal text.
+	ParseBare Mode = 1 << iota
+
+	// ParseArithmetic enables parsing of arithmetic expansions such
+	// as $((x+1)). By default these are treated as literal text.
+	ParseArithmetic
+
+	// ParseLiteralDollars disables the $$ escape, so that $$ is
+	// parsed as literal text, like GNU envsubst. By default $$ is
+	// parsed as a single dollar sign.
+	ParseLiteralDollars
 )
 
 // Tree is the representation of a single parsed SQL statement.
 type Tree struct {
 	Root Node
+	Mode Mode // controls parser behavior
 
 	// Parsing only; cleared after parse.
 	scanner *scanner
+	source  string
+	funcs   map[string]bool
 }
 
 // Parse parses the string and returns a Tree.
 func Parse(buf string) (*Tree, error) {
+	return ParseMode(buf, 0)
+}
+
+// ParseMode parses the string using the specified mode and
+// returns a Tree.
+func ParseMode(buf string, mode Mode) (*Tree, error) {
+	return ParseFuncs(buf, mode, nil)
+}
+
+// ParseFuncs parses the string using the specified mode and returns
+// a Tree. The funcs map defines the names of the functions that may
+// be used in pipe functions such as ${var|func}.
+func ParseFuncs(buf string, mode Mode, funcs map[string]bool) (*Tree, error) {
 	t := new(Tree)
+	t.Mode = mode
 	t.scanner = new(scanner)
+	t.funcs = funcs
 	return t.Parse(buf)
 }
 
 // Parse parses the string buffer to construct an ast
 // representation for expansion.
 func (t *Tree) Parse(buf string) (tree *Tree, err error) {
+	t.source = buf
 	t.scanner.init(buf)
 	t.Root, err = t.parseAny()
 	return t, err
@@ -49,10 +95,10 @@ func (t *Tree) Parse(buf string) (tree *Tree, err error) {
 
 func (t *Tree) parseAny() (Node, error) {
 	t.scanner.accept = acceptRune
-	t.scanner.mode = scanIdent | scanLbrack | scanEscape
-	t.scanner.escapeChars = dollar
+	t.scanner.mode = scanIdent | scanEscape | t.scanSubst()
+	t.scanner.escapeChars = t.escapes(dollar)
 
-	switch t.scanner.scan() {
+	switch tok := t.scanner.scan(); tok {
 	case tokenIdent:
 		left := newTextNode(
 			t.scanner.string(),
@@ -67,8 +113,8 @@ func (t *Tree) parseAny() (Node, error) {
 		return newListNode(left, right), nil
 	case tokenEOF:
 		return empty, nil
-	case tokenLbrack:
-		left, err := t.parseFunc()
+	case tokenLbrack, tokenVar, tokenArith:
+		left, err := t.parseSubst(tok)
 		if err != nil {
 			return nil, err
 		}
@@ -83,12 +129,51 @@ func (t *Tree) parseAny() (Node, error) {
 		return newListNode(left, right), nil
 	}
 
-	return nil, ErrBadSubstitution
+	return nil, t.errorf(t.scanner.position(), ErrBadSubstitution)
+}
+
+// parse a braced or unbraced substitution, or an arithmetic
+// expansion. The opening bracket, the unbraced variable reference,
+// or the opening $(( has already been scanned.
+func (t *Tree) parseSubst(tok token) (Node, error) {
+	switch tok {
+	case tokenVar:
+		return t.parseVar(), nil
+	case tokenArith:
+		return t.parseArith()
+	}
+	return t.parseFunc()
+}
+
+// parses the unbraced $param variable reference.
+func (t *Tree) parseVar() Node {
+	node := new(ParamNode)
+	node.Name = t.scanner.string()[1:]
+	node.Bare = true
+	node.setPosition(t.scanner.position(), t.scanner.end())
+	return node
+}
+
+// substNode is a substitution node with a source position.
+type substNode interface {
+	Node
+	setPosition(pos, end int)
 }
 
 func (t *Tree) parseFunc() (Node, error) {
+	pos := t.scanner.position()
+	node, err := t.parseFuncExpr()
+	if err != nil {
+		return nil, t.errorf(pos, err)
+	}
+	node.setPosition(pos, t.scanner.end())
+	return node, nil
+}
+
+// parse the function expression following the opening bracket.
+func (t *Tree) parseFuncExpr() (substNode, error) {
 	// Turn on all escape characters
-	t.scanner.escapeChars = escapeAll
+	t.scanner.escapeChars = t.escapes(escapeAll)
 	switch t.scanner.peek() {
 	case '#':
 		return t.parseLenFunc()
@@ -108,7 +193,7 @@ func (t *Tree) parseFunc() (Node, error) {
 	switch t.scanner.peek() {
 	case ':':
 		return t.parseDefaultOrSubstr(name)
-	case '=':
+	case '=', '-', '?', '+':
 		return t.parseDefaultFunc(name)
 	case ',', '^':
 		return t.parseCasingFunc(name)
@@ -118,13 +203,15 @@ func (t *Tree) parseFunc() (Node, error) {
 		return t.parseRemoveFunc(name, acceptHashFunc)
 	case '%':
 		return t.parseRemoveFunc(name, acceptPercentFunc)
+	case '|':
+		return t.parsePipeFunc(name)
 	}
 
 	t.scanner.accept = acceptIdent
 	t.scanner.mode = scanRbrack
 	switch t.scanner.scan() {
 	case tokenRbrack:
-		return newFuncNode(name), nil
+		return &ParamNode{Name: name}, nil
 	default:
 		return nil, ErrMissingClosingBrace
 	}
@@ -133,10 +220,14 @@ func (t *Tree) parseFunc() (Node, error) {
 // parse a substitution function parameter.
 func (t *Tree) parseParam(accept acceptFunc, mode byte) (Node, error) {
 	t.scanner.accept = accept
-	t.scanner.mode = mode | scanLbrack
+	t.scanner.mode = mode | t.scanSubst()
 	switch t.scanner.scan() {
 	case tokenLbrack:
 		return t.parseFunc()
+	case tokenVar:
+		return t.parseVar(), nil
+	case tokenArith:
+		return t.parseArith()
 	case tokenIdent:
 		return newTextNode(
 			t.scanner.string(),
@@ -145,13 +236,15 @@ func (t *Tree) parseParam(accept acceptFunc, mode byte) (Node, error) {
 		return newTextNode(
 			t.scanner.string(),
 		), nil
+	case tokenEOF:
+		return nil, ErrMissingClosingBrace
 	default:
 		return nil, ErrParseFuncSubstitution
 	}
 }
 
 // parse either a default or substring substitution function.
-func (t *Tree) parseDefaultOrSubstr(name string) (Node, error) {
+func (t *Tree) parseDefaultOrSubstr(name string) (substNode, error) {
 	t.scanner.read()
 	r := t.scanner.peek()
 	t.scanner.unread()
@@ -165,28 +258,26 @@ func (t *Tree) parseDefaultOrSubstr(name string) (Node, error) {
 
 // parses the ${param:offset} string function
 // parses the ${param:offset:length} string function
-func (t *Tree) parseSubstrFunc(name string) (Node, error) {
-	node := new(FuncNode)
-	node.Param = name
+func (t *Tree) parseSubstrFunc(name string) (substNode, error) {
+	node := new(SubstrNode)
+	node.Name = name
 
 	t.scanner.accept = acceptOneColon
 	t.scanner.mode = scanIdent
 	switch t.scanner.scan() {
 	case tokenIdent:
-		node.Name = t.scanner.string()
+		// no-op
 	default:
 		return nil, ErrBadSubstitution
 	}
 
-	// scan arg[1]
+	// scan offset
 	{
-		param, err := t.parseParam(rejectColonClose, scanIdent)
+		param, err := t.parseArithParam(rejectColonClose)
 		if err != nil {
 			return nil, err
 		}
-
-		// param.Value = t.scanner.string()
-		node.Args = append(node.Args, param)
+		node.Offset = param
 	}
 
 	// expect delimiter or close
@@ -201,44 +292,140 @@ func (t *Tree) parseSubstrFunc(name string) (Node, error) {
 		return nil, ErrBadSubstitution
 	}
 
-	// scan arg[2]
+	// scan length
 	{
-		param, err := t.parseParam(acceptNotClosing, scanIdent)
+		param, err := t.parseArithParam(acceptNotClosing)
 		if err != nil {
 			return nil, err
 		}
-		node.Args = append(node.Args, param)
+		node.Length = param
 	}
 
 	return node, t.consumeRbrack()
 }
 
+// parse an arithmetic function parameter, which may contain
+// substitutions, up to the first rune that is not accepted. The
+// parameter may be empty, which evaluates to 0.
+func (t *Tree) parseArithParam(accept acceptFunc) (Node, error) {
+	var nodes []Node
+	for {
+		r := t.scanner.peek()
+		if r == eof {
+			return nil, ErrMissingClosingBrace
+		}
+		if !accept(r, 0) {
+			break
+		}
+		param, err := t.parseParam(accept, scanIdent)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, param)
+	}
+	node := joinNodes(nodes)
+	return node, checkArith(node)
+}
+
+// parses the $((expression)) arithmetic expansion.
+func (t *Tree) parseArith() (Node, error) {
+	pos := t.scanner.position()
+	node, err := t.parseArithExpr()
+	if err != nil {
+		return nil, t.errorf(pos, err)
+	}
+	node.setPosition(pos, t.scanner.end())
+	return node, nil
+}
+
+// parse the arithmetic expression following the opening $((, up to
+// the closing )) that is not matched by an opening parenthesis.
+func (t *Tree) parseArithExpr() (*ArithNode, error) {
+	t.scanner.escapeChars = 0
+	depth := 0
+	accept := func(r rune, i int) bool {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				return false
+			}
+			depth--
+		}
+		return true
+	}
+
+	var nodes []Node
+	for depth > 0 || t.scanner.peek() != ')' {
+		if t.scanner.peek() == eof {
+			return nil, ErrMissingClosingParen
+		}
+		param, err := t.parseParam(accept, scanIdent|scanVar)
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, param)
+	}
+	if t.scanner.read() != ')' || t.scanner.read() != ')' {
+		return nil, ErrMissingClosingParen
+	}
+
+	node := new(ArithNode)
+	node.Expr = joinNodes(nodes)
+	return node, checkArith(node.Expr)
+}
+
+// joinNodes returns a single node for the list of nodes.
+func joinNodes(nodes []Node) Node {
+	switch len(nodes) {
+	case 0:
+		return newTextNode("")
+	case 1:
+		return nodes[0]
+	default:
+		return newListNode(nodes...)
+	}
+}
+
+// checkArith returns ErrArithSyntax if the node is text that is
+// not a valid arithmetic expression. Nodes that contain substitutions
+// are checked when evaluated.
+func checkArith(node Node) error {
+	if text, ok := node.(*TextNode); ok {
+		if _, err := ParseArith(text.Value); err != nil {
+			return ErrArithSyntax
+		}
+	}
+	return nil
+}
+
 // parses the ${param%word} string function
 // parses the ${param%%word} string function
 // parses the ${param#word} string function
 // parses the ${param##word} string function
-func (t *Tree) parseRemoveFunc(name string, accept acceptFunc) (Node, error) {
-	node := new(FuncNode)
-	node.Param = name
+func (t *Tree) parseRemoveFunc(name string, accept acceptFunc) (substNode, error) {
+	node := new(TrimNode)
+	node.Name = name
 
 	t.scanner.accept = accept
 	t.scanner.mode = scanIdent
 	switch t.scanner.scan() {
 	case tokenIdent:
-		node.Name = t.scanner.string()
+		op := t.scanner.string()
+		node.Suffix = op[0] == '%'
+		node.Longest = len(op) == 2
 	default:
 		return nil, ErrBadSubstitution
 	}
 
-	// scan arg[1]
+	// scan pattern
 	{
 		param, err := t.parseParam(acceptNotClosing, scanIdent)
 		if err != nil {
 			return nil, err
 		}
-
-		// param.Value = t.scanner.string()
-		node.Args = append(node.Args, param)
+		node.Pattern = param
 	}
 
 	return node, t.consumeRbrack()
@@ -248,26 +435,36 @@ func (t *Tree) parseRemoveFunc(name string, accept acceptFunc) (Node, error) {
 // parses the ${param//pattern/string} string function
 // parses the ${param/#pattern/string} string function
 // parses the ${param/%pattern/string} string function
-func (t *Tree) parseReplaceFunc(name string) (Node, error) {
-	node := new(FuncNode)
-	node.Param = name
+func (t *Tree) parseReplaceFunc(name string) (substNode, error) {
+	node := new(ReplaceNode)
+	node.Name = name
 
 	t.scanner.accept = acceptReplaceFunc
 	t.scanner.mode = scanIdent
 	switch t.scanner.scan() {
 	case tokenIdent:
-		node.Name = t.scanner.string()
+		switch t.scanner.string() {
+		case "//":
+			node.All = true
+		case "/#":
+			node.Prefix = true
+		case "/%":
+			node.Suffix = true
+		}
 	default:
 		return nil, ErrBadSubstitution
 	}
 
-	// scan arg[1]
-	{
+	// scan pattern, which may be empty
+	switch t.scanner.peek() {
+	case '/':
+		node.Pattern = newTextNode("")
+	default:
 		param, err := t.parseParam(acceptNotSlash, scanIdent|scanEscape)
 		if err != nil {
 			return nil, err
 		}
-		node.Args = append(node.Args, param)
+		node.Pattern = param
 	}
 
 	// expect delimiter
@@ -283,71 +480,125 @@ func (t *Tree) parseReplaceFunc(name string) (Node, error) {
 	// check for blank string
 	switch t.scanner.peek() {
 	case '}':
+		node.Replacement = newTextNode("")
 		return node, t.consumeRbrack()
 	}
 
-	// scan arg[2]
+	// scan replacement
 	{
 		param, err := t.parseParam(acceptNotClosing, scanIdent|scanEscape)
 		if err != nil {
 			return nil, err
 		}
-		node.Args = append(node.Args, param)
+		node.Replacement = param
 	}
 
 	return node, t.consumeRbrack()
 }
 
+// parses the ${parameter|func} pipe function, which passes the
+// value through one or more functions, such as ${parameter|f|g}.
+func (t *Tree) parsePipeFunc(name string) (substNode, error) {
+	node := new(PipeNode)
+	node.Name = name
+
+	for t.scanner.peek() == '|' {
+		t.scanner.read()
+		t.scanner.accept = acceptIdent
+		t.scanner.mode = scanIdent
+		switch t.scanner.scan() {
+		case tokenIdent:
+			// no-op
+		case tokenEOF:
+			return nil, ErrMissingClosingBrace
+		default:
+			return nil, ErrBadSubstitution
+		}
+		fn := t.scanner.string()
+		if !t.funcs[fn] {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownFunction, fn)
+		}
+		node.Funcs = append(node.Funcs, fn)
+	}
+
+	if t.scanner.peek() == eof {
+		return nil, ErrMissingClosingBrace
+	}
+	return node, t.consumeRbrack()
+}
+
+// parses the ${parameter-word} string function
+// parses the ${parameter?word} string function
+// parses the ${parameter+word} string function
 // parses the ${parameter=word} string function
 // parses the ${parameter:=word} string function
 // parses the ${parameter:-word} string function
 // parses the ${parameter:?word} string function
 // parses the ${parameter:+word} string function
-func (t *Tree) parseDefaultFunc(name string) (Node, error) {
-	node := new(FuncNode)
-	node.Param = name
-
+func (t *Tree) parseDefaultFunc(name string) (substNode, error) {
 	t.scanner.accept = acceptDefaultFunc
-	if t.scanner.peek() == '=' {
+	switch t.scanner.peek() {
+	case '=':
 		t.scanner.accept = acceptOneEqual
+	case '-':
+		t.scanner.accept = acceptOneDash
+	case '?':
+		t.scanner.accept = acceptOneQuestion
+	case '+':
+		t.scanner.accept = acceptOnePlus
 	}
 	t.scanner.mode = scanIdent
+	var op string
 	switch t.scanner.scan() {
 	case tokenIdent:
-		node.Name = t.scanner.string()
+		op = t.scanner.string()
 	default:
 		return nil, ErrParseDefaultFunction
 	}
 
 	// loop through all possible runes in default param
+	var nodes []Node
 	for {
 		// this acts as the break condition. Peek to see if we reached the end
-		switch t.scanner.peek() {
-		case '}':
-			return node, t.consumeRbrack()
+		if t.scanner.peek() == '}' {
+			break
 		}
 		param, err := t.parseParam(acceptNotClosing, scanIdent)
 		if err != nil {
 			return nil, err
 		}
+		nodes = append(nodes, param)
+	}
 
-		node.Args = append(node.Args, param)
+	var node substNode
+	word := joinNodes(nodes)
+	colon := op[0] == ':'
+	switch op[len(op)-1] {
+	case '?':
+		node = &RequiredNode{Name: name, Message: word, Colon: colon}
+	case '+':
+		node = &AlternateNode{Name: name, Value: word, Colon: colon}
+	default:
+		node = &DefaultNode{Name: name, Value: word, Colon: colon, Assign: op[len(op)-1] == '='}
 	}
+	return node, t.consumeRbrack()
 }
 
 // parses the ${param,} string function
 // parses the ${param,,} string function
 // parses the ${param^} string function
 // parses the ${param^^} string function
-func (t *Tree) parseCasingFunc(name string) (Node, error) {
-	node := new(FuncNode)
-	node.Param = name
+func (t *Tree) parseCasingFunc(name string) (substNode, error) {
+	node := new(CaseNode)
+	node.Name = name
 
 	t.scanner.accept = acceptCasingFunc
 	t.scanner.mode = scanIdent
 	switch t.scanner.scan() {
 	case tokenIdent:
-		node.Name = t.scanner.string()
+		op := t.scanner.string()
+		node.Upper = op[0] == '^'
+		node.First = len(op) == 1
 	default:
 		return nil, ErrBadSubstitution
 	}
@@ -356,14 +607,14 @@ func (t *Tree) parseCasingFunc(name string) (Node, error) {
 }
 
 // parses the ${#param} string function
-func (t *Tree) parseLenFunc() (Node, error) {
-	node := new(FuncNode)
+func (t *Tree) parseLenFunc() (substNode, error) {
+	node := new(LenNode)
 
 	t.scanner.accept = acceptOneHash
 	t.scanner.mode = scanIdent
 	switch t.scanner.scan() {
 	case tokenIdent:
-		node.Name = t.scanner.string()
+		// no-op
 	default:
 		return nil, ErrBadSubstitution
 	}
@@ -372,7 +623,7 @@ func (t *Tree) parseLenFunc() (Node, error) {
 	t.scanner.mode = scanIdent
 	switch t.scanner.scan() {
 	case tokenIdent:
-		node.Param = t.scanner.string()
+		node.Name = t.scanner.string()
 	default:
 		return nil, ErrBadSubstitution
 	}
@@ -380,6 +631,39 @@ func (t *Tree) parseLenFunc() (Node, error) {
 	return node, t.consumeRbrack()
 }
 
+// errorf returns an Error for the expression starting at the byte
+// offset pos and ending at the current scanner position. Errors that
+// already include a position are returned unchanged, so that errors
+// refer to the innermost expression.
+func (t *Tree) errorf(pos int, err error) error {
+	if _, ok := err.(*Error); ok {
+		return err
+	}
+	return newError(err, t.source, pos, t.scanner.end())
+}
+
+// scanSubst returns the scanner mode bits that recognize the start
+// of a substitution.
+func (t *Tree) scanSubst() byte {
+	mode := scanLbrack
+	if t.Mode&ParseBare != 0 {
+		mode |= scanVar
+	}
+	if t.Mode&ParseArithmetic != 0 {
+		mode |= scanArith
+	}
+	return mode
+}
+
+// escapes returns the escape characters, without the dollar sign
+// when the $$ escape is disabled.
+func (t *Tree) escapes(chars byte) byte {
+	if t.Mode&ParseLiteralDollars != 0 {
+		return chars &^ dollar
+	}
+	return chars
+}
+
 // consumeRbrack consumes a right closing bracket. If a closing
 // bracket token is not consumed an ErrBadSubstitution is returned.
 func (t *Tree) consumeRbrack() error {
@@ -1,9 +1,12 @@
 package parse
 
 import (
+	"errors"
+	"reflect"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 )
 
 var tests = []struct {
@@ -44,7 +47,7 @@ var tests = []struct {
 	//
 	{
 		Text: "${string}",
-		Node: &FuncNode{Param: "string"},
+		Node: &ParamNode{Name: "string"},
 	},
 
 	//
@@ -52,35 +55,19 @@ var tests = []struct {
 	//
 	{
 		Text: "${string,}",
-		Node: &FuncNode{
-			Param: "string",
-			Name:  ",",
-			Args:  nil,
-		},
+		Node: &CaseNode{Name: "string", First: true},
 	},
 	{
 		Text: "${string,,}",
-		Node: &FuncNode{
-			Param: "string",
-			Name:  ",,",
-			Args:  nil,
-		},
+		Node: &CaseNode{Name: "string"},
 	},
 	{
 		Text: "${string^}",
-		Node: &FuncNode{
-			Param: "string",
-			Name:  "^",
-			Args:  nil,
-		},
+		Node: &CaseNode{Name: "string", Upper: true, First: true},
 	},
 	{
 		Text: "${string^^}",
-		Node: &FuncNode{
-			Param: "string",
-			Name:  "^^",
-			Args:  nil,
-		},
+		Node: &CaseNode{Name: "string", Upper: true},
 	},
 
 	//
@@ -88,67 +75,78 @@ var tests = []struct {
 	//
 	{
 		Text: "${string:position}",
-		Node: &FuncNode{
-			Param: "string",
-			Name:  ":",
-			Args: []Node{
-				&TextNode{Value: "position"},
-			},
+		Node: &SubstrNode{
+			Name:   "string",
+			Offset: &TextNode{Value: "position"},
 		},
 	},
 	{
 		Text: "${string:position:length}",
-		Node: &FuncNode{
-			Param: "string",
-			Name:  ":",
-			Args: []Node{
-				&TextNode{Value: "position"},
-				&TextNode{Value: "length"},
+		Node: &SubstrNode{
+			Name:   "string",
+			Offset: &TextNode{Value: "position"},
+			Length: &TextNode{Value: "length"},
+		},
+	},
+	{
+		Text: "${string:1+2:${#string}-4}",
+		Node: &SubstrNode{
+			Name:   "string",
+			Offset: &TextNode{Value: "1+2"},
+			Length: &ListNode{
+				Nodes: []Node{
+					&LenNode{Name: "string"},
+					&TextNode{Value: "-4"},
+				},
 			},
 		},
 	},
+	{
+		Text: "${string: -2}",
+		Node: &SubstrNode{Name: "string", Offset: &TextNode{Value: " -2"}},
+	},
+	{
+		Text: "${string::(2)}",
+		Node: &SubstrNode{
+			Name:   "string",
+			Offset: &TextNode{Value: ""},
+			Length: &TextNode{Value: "(2)"},
+		},
+	},
 
 	//
 	// string removal functions
 	//
 	{
 		Text: "${string#substring}",
-		Node: &FuncNode{
-			Param: "string",
-			Name:  "#",
-			Args: []Node{
-				&TextNode{Value: "substring"},
-			},
+		Node: &TrimNode{
+			Name:    "string",
+			Pattern: &TextNode{Value: "substring"},
 		},
 	},
 	{
 		Text: "${string##substring}",
-		Node: &FuncNode{
-			Param: "string",
-			Name:  "##",
-			Args: []Node{
-				&TextNode{Value: "substring"},
-			},
+		Node: &TrimNode{
+			Name:    "string",
+			Pattern: &TextNode{Value: "substring"},
+			Longest: true,
 		},
 	},
 	{
 		Text: "${string%substring}",
-		Node: &FuncNode{
-			Param: "string",
-			Name:  "%",
-			Args: []Node{
-				&TextNode{Value: "substring"},
-			},
+		Node: &TrimNode{
+			Name:    "string",
+			Pattern: &TextNode{Value: "substring"},
+			Suffix:  true,
 		},
 	},
 	{
 		Text: "${string%%substring}",
-		Node: &FuncNode{
-			Param: "string",
-			Name:  "%%",
-			Args: []Node{
-				&TextNode{Value: "substring"},
-			},
+		Node: &TrimNode{
+			Name:    "string",
+			Pattern: &TextNode{Value: "substring"},
+			Suffix:  true,
+			Longest: true,
 		},
 	},
 
@@ -157,100 +155,117 @@ var tests = []struct {
 	//
 	{
 		Text: "${string/substring/replacement}",
-		Node: &FuncNode{
-			Param: "string",
-			Name:  "/",
-			Args: []Node{
-				&TextNode{Value: "substring"},
-				&TextNode{Value: "replacement"},
-			},
+		Node: &ReplaceNode{
+			Name:        "string",
+			Pattern:     &TextNode{Value: "substring"},
+			Replacement: &TextNode{Value: "replacement"},
 		},
 	},
 	{
 		Text: "${string//substring/replacement}",
-		Node: &FuncNode{
-			Param: "string",
-			Name:  "//",
-			Args: []Node{
-				&TextNode{Value: "substring"},
-				&TextNode{Value: "replacement"},
-			},
+		Node: &ReplaceNode{
+			Name:        "string",
+			Pattern:     &TextNode{Value: "substring"},
+			Replacement: &TextNode{Value: "replacement"},
+			All:         true,
 		},
 	},
 	{
 		Text: "${string/#substring/replacement}",
-		Node: &FuncNode{
-			Param: "string",
-			Name:  "/#",
-			Args: []Node{
-				&TextNode{Value: "substring"},
-				&TextNode{Value: "replacement"},
-			},
+		Node: &ReplaceNode{
+			Name:        "string",
+			Pattern:     &TextNode{Value: "substring"},
+			Replacement: &TextNode{Value: "replacement"},
+			Prefix:      true,
 		},
 	},
 	{
 		Text: "${string/%substring/replacement}",
-		Node: &FuncNode{
-			Param: "string",
-			Name:  "/%",
-			Args: []Node{
-				&TextNode{Value: "substring"},
-				&TextNode{Value: "replacement"},
-			},
+		Node: &ReplaceNode{
+			Name:        "string",
+			Pattern:     &TextNode{Value: "substring"},
+			Replacement: &TextNode{Value: "replacement"},
+			Suffix:      true,
+		},
+	},
+
+	{
+		Text: "${string/#/prefix}",
+		Node: &ReplaceNode{
+			Name:        "string",
+			Pattern:     &TextNode{Value: ""},
+			Replacement: &TextNode{Value: "prefix"},
+			Prefix:      true,
 		},
 	},
 
 	//
 	// default value functions
 	//
+	{
+		Text: "${string-default}",
+		Node: &DefaultNode{
+			Name:  "string",
+			Value: &TextNode{Value: "default"},
+		},
+	},
 	{
 		Text: "${string=default}",
-		Node: &FuncNode{
-			Param: "string",
-			Name:  "=",
-			Args: []Node{
-				&TextNode{Value: "default"},
-			},
+		Node: &DefaultNode{
+			Name:   "string",
+			Value:  &TextNode{Value: "default"},
+			Assign: true,
 		},
 	},
 	{
 		Text: "${string:=default}",
-		Node: &FuncNode{
-			Param: "string",
-			Name:  ":=",
-			Args: []Node{
-				&TextNode{Value: "default"},
-			},
+		Node: &DefaultNode{
+			Name:   "string",
+			Value:  &TextNode{Value: "default"},
+			Colon:  true,
+			Assign: true,
 		},
 	},
 	{
 		Text: "${string:-default}",
-		Node: &FuncNode{
-			Param: "string",
-			Name:  ":-",
-			Args: []Node{
-				&TextNode{Value: "default"},
-			},
+		Node: &DefaultNode{
+			Name:  "string",
+			Value: &TextNode{Value: "default"},
+			Colon: true,
 		},
 	},
 	{
 		Text: "${string:?default}",
-		Node: &FuncNode{
-			Param: "string",
-			Name:  ":?",
-			Args: []Node{
-				&TextNode{Value: "default"},
-			},
+		Node: &RequiredNode{
+			Name:    "string",
+			Message: &TextNode{Value: "default"},
+			Colon:   true,
+		},
+	},
+	{
+		Text: "${string?default}",
+		Node: &RequiredNode{
+			Name:    "string",
+			Message: &TextNode{Value: "default"},
+		},
+	},
+	{
+		Text: "${string?}",
+		Node: &RequiredNode{Name: "string", Message: &TextNode{}},
+	},
+	{
+		Text: "${string+default}",
+		Node: &AlternateNode{
+			Name:  "string",
+			Value: &TextNode{Value: "default"},
 		},
 	},
 	{
 		Text: "${string:+default}",
-		Node: &FuncNode{
-			Param: "string",
-			Name:  ":+",
-			Args: []Node{
-				&TextNode{Value: "default"},
-			},
+		Node: &AlternateNode{
+			Name:  "string",
+			Value: &TextNode{Value: "default"},
+			Colon: true,
 		},
 	},
 
@@ -259,10 +274,7 @@ var tests = []struct {
 	//
 	{
 		Text: "${#string}",
-		Node: &FuncNode{
-			Param: "string",
-			Name:  "#",
-		},
+		Node: &LenNode{Name: "string"},
 	},
 
 	//
@@ -270,12 +282,9 @@ var tests = []struct {
 	//
 	{
 		Text: "${string#$%:*{}",
-		Node: &FuncNode{
-			Param: "string",
-			Name:  "#",
-			Args: []Node{
-				&TextNode{Value: "$%:*{"},
-			},
+		Node: &TrimNode{
+			Name:    "string",
+			Pattern: &TextNode{Value: "$%:*{"},
 		},
 	},
 
@@ -289,10 +298,7 @@ var tests = []struct {
 				},
 				&ListNode{
 					Nodes: []Node{
-						&FuncNode{
-							Param: "string",
-							Name:  "#",
-						},
+						&LenNode{Name: "string"},
 						&TextNode{
 							Value: " world",
 						},
@@ -311,10 +317,7 @@ var tests = []struct {
 				},
 				&ListNode{
 					Nodes: []Node{
-						&FuncNode{
-							Param: "string",
-							Name:  "#",
-						},
+						&LenNode{Name: "string"},
 						&TextNode{
 							Value: ` world \\`,
 						},
@@ -327,76 +330,61 @@ var tests = []struct {
 	// escaped function arguments
 	{
 		Text: `${string/\/position/length}`,
-		Node: &FuncNode{
-			Param: "string",
-			Name:  "/",
-			Args: []Node{
-				&TextNode{
-					Value: "/position",
-				},
-				&TextNode{
-					Value: "length",
-				},
+		Node: &ReplaceNode{
+			Name: "string",
+			Pattern: &TextNode{
+				Value: "/position",
+			},
+			Replacement: &TextNode{
+				Value: "length",
 			},
 		},
 	},
 	{
 		Text: `${string/\/position\\/length}`,
-		Node: &FuncNode{
-			Param: "string",
-			Name:  "/",
-			Args: []Node{
-				&TextNode{
-					Value: "/position\\",
-				},
-				&TextNode{
-					Value: "length",
-				},
+		Node: &ReplaceNode{
+			Name: "string",
+			Pattern: &TextNode{
+				Value: "/position\\",
+			},
+			Replacement: &TextNode{
+				Value: "length",
 			},
 		},
 	},
 	{
 		Text: `${string/position/\/length}`,
-		Node: &FuncNode{
-			Param: "string",
-			Name:  "/",
-			Args: []Node{
-				&TextNode{
-					Value: "position",
-				},
-				&TextNode{
-					Value: "/length",
-				},
+		Node: &ReplaceNode{
+			Name: "string",
+			Pattern: &TextNode{
+				Value: "position",
+			},
+			Replacement: &TextNode{
+				Value: "/length",
 			},
 		},
 	},
 	{
 		Text: `${string/position/\/length\\}`,
-		Node: &FuncNode{
-			Param: "string",
-			Name:  "/",
-			Args: []Node{
-				&TextNode{
-					Value: "position",
-				},
-				&TextNode{
-					Value: "/length\\",
-				},
+		Node: &ReplaceNode{
+			Name: "string",
+			Pattern: &TextNode{
+				Value: "position",
+			},
+			Replacement: &TextNode{
+				Value: "/length\\",
 			},
 		},
 	},
 	{
 		Text: `${string/position/\/leng\\th}`,
-		Node: &FuncNode{
-			Param: "string",
-			Name:  "/",
-			Args: []Node{
-				&TextNode{
-					Value: "position",
-				},
-				&TextNode{
-					Value: "/leng\\th",
-				},
+		Node: &ReplaceNode{
+			Name: "string",
+			Pattern: &TextNode{
+				Value: "position",
+			},
+			Replacement: &TextNode{
+				Value: "/leng\\th",
 			},
 		},
 	},
@@ -404,112 +392,99 @@ var tests = []struct {
 	// functions in functions
 	{
 		Text: "${string:${position}}",
-		Node: &FuncNode{
-			Param: "string",
-			Name:  ":",
-			Args: []Node{
-				&FuncNode{
-					Param: "position",
-				},
-			},
+		Node: &SubstrNode{
+			Name:   "string",
+			Offset: &ParamNode{Name: "position"},
 		},
 	},
 	{
 		Text: "${string:${stringy:position:length}:${stringz,,}}",
-		Node: &FuncNode{
-			Param: "string",
-			Name:  ":",
-			Args: []Node{
-				&FuncNode{
-					Param: "stringy",
-					Name:  ":",
-					Args: []Node{
-						&TextNode{Value: "position"},
-						&TextNode{Value: "length"},
-					},
-				},
-				&FuncNode{
-					Param: "stringz",
-					Name:  ",,",
-				},
+		Node: &SubstrNode{
+			Name: "string",
+			Offset: &SubstrNode{
+				Name:   "stringy",
+				Offset: &TextNode{Value: "position"},
+				Length: &TextNode{Value: "length"},
 			},
+			Length: &CaseNode{Name: "stringz"},
 		},
 	},
 	{
 		Text: "${string#${stringz}}",
-		Node: &FuncNode{
-			Param: "string",
-			Name:  "#",
-			Args: []Node{
-				&FuncNode{Param: "stringz"},
-			},
+		Node: &TrimNode{
+			Name:    "string",
+			Pattern: &ParamNode{Name: "stringz"},
 		},
 	},
 	{
 		Text: "${string=${stringz}}",
-		Node: &FuncNode{
-			Param: "string",
-			Name:  "=",
-			Args: []Node{
-				&FuncNode{Param: "stringz"},
-			},
+		Node: &DefaultNode{
+			Name:   "string",
+			Value:  &ParamNode{Name: "stringz"},
+			Assign: true,
 		},
 	},
 	{
 		Text: "${string=prefix-${var}}",
-		Node: &FuncNode{
-			Param: "string",
-			Name:  "=",
-			Args: []Node{
-				&TextNode{Value: "prefix-"},
-				&FuncNode{Param: "var"},
+		Node: &DefaultNode{
+			Name: "string",
+			Value: &ListNode{
+				Nodes: []Node{
+					&TextNode{Value: "prefix-"},
+					&ParamNode{Name: "var"},
+				},
 			},
+			Assign: true,
 		},
 	},
 	{
 		Text: "${string=${var}-suffix}",
-		Node: &FuncNode{
-			Param: "string",
-			Name:  "=",
-			Args: []Node{
-				&FuncNode{Param: "var"},
-				&TextNode{Value: "-suffix"},
+		Node: &DefaultNode{
+			Name: "string",
+			Value: &ListNode{
+				Nodes: []Node{
+					&ParamNode{Name: "var"},
+					&TextNode{Value: "-suffix"},
+				},
 			},
+			Assign: true,
 		},
 	},
 	{
 		Text: "${string=prefix-${var}-suffix}",
-		Node: &FuncNode{
-			Param: "string",
-			Name:  "=",
-			Args: []Node{
-				&TextNode{Value: "prefix-"},
-				&FuncNode{Param: "var"},
-				&TextNode{Value: "-suffix"},
+		Node: &DefaultNode{
+			Name: "string",
+			Value: &ListNode{
+				Nodes: []Node{
+					&TextNode{Value: "prefix-"},
+					&ParamNode{Name: "var"},
+					&TextNode{Value: "-suffix"},
+				},
 			},
+			Assign: true,
 		},
 	},
 	{
 		Text: "${string=prefix${var} suffix}",
-		Node: &FuncNode{
-			Param: "string",
-			Name:  "=",
-			Args: []Node{
-				&TextNode{Value: "prefix"},
-				&FuncNode{Param: "var"},
-				&TextNode{Value: " suffix"},
+		Node: &DefaultNode{
+			Name: "string",
+			Value: &ListNode{
+				Nodes: []Node{
+					&TextNode{Value: "prefix"},
+					&ParamNode{Name: "var"},
+					&TextNode{Value: " suffix"},
+				},
 			},
+			Assign: true,
 		},
 	},
 	{
 		Text: "${string//${stringy}/${stringz}}",
-		Node: &FuncNode{
-			Param: "string",
-			Name:  "//",
-			Args: []Node{
-				&FuncNode{Param: "stringy"},
-				&FuncNode{Param: "stringz"},
-			},
+		Node: &ReplaceNode{
+			Name:        "string",
+			Pattern:     &ParamNode{Name: "stringy"},
+			Replacement: &ParamNode{Name: "stringz"},
+			All:         true,
 		},
 	},
 }
@@ -523,9 +498,469 @@ func TestParse(t *testing.T) {
 				t.Error(err)
 			}
 
-			if diff := cmp.Diff(test.Node, got.Root); diff != "" {
+			if diff := cmp.Diff(test.Node, got.Root, ignorePos); diff != "" {
+				t.Errorf(diff)
+			}
+		})
+	}
+}
+
+// ignorePos ignores source positions when comparing nodes.
+var ignorePos = cmpopts.IgnoreTypes(Position{})
+
+func TestParsePos(t *testing.T) {
+	tree, err := Parse("$${a} ${b} ${c:-${d}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got [][2]int
+	Inspect(tree.Root, func(node Node) bool {
+		if pos, ok := nodePosition(node); ok {
+			got = append(got, [2]int{pos.Pos, pos.End})
+		}
+		return true
+	})
+	want := [][2]int{{6, 10}, {11, 21}, {16, 20}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf(diff)
+	}
+}
+
+func TestNodeString(t *testing.T) {
+	text := "text ${a} $b ${#c} ${d,} ${e^^} ${f:1:${g}} ${h: -2} ${i%%x*} " +
+		"${j#${k}} ${l/a/b} ${m//a/} ${n/#a/b} ${o/%a/} ${p-} ${q:=x${r}y} " +
+		"${s?message} ${t:?} ${u+v} ${w:+x} $((1 + ${y:-2} * (z))) ${x|f|g}"
+	tree, err := ParseFuncs(text, ParseBare|ParseArithmetic, map[string]bool{"f": true, "g": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tree.Root.String(); got != text {
+		t.Errorf("Want tree string %q, got %q", text, got)
+	}
+
+	// the string of each node is its source text.
+	Inspect(tree.Root, func(node Node) bool {
+		pos, ok := nodePosition(node)
+		if !ok {
+			return true
+		}
+		if got, want := node.String(), text[pos.Pos:pos.End]; got != want {
+			t.Errorf("Want node string %q, got %q", want, got)
+		}
+		return true
+	})
+}
+
+// nodePosition returns the source position of the node, and reports
+// whether the node has a source position.
+func nodePosition(node Node) (Position, bool) {
+	v := reflect.ValueOf(node)
+	if v.Kind() != reflect.Ptr {
+		return Position{}, false
+	}
+	f := v.Elem().FieldByName("Position")
+	if !f.IsValid() {
+		return Position{}, false
+	}
+	return f.Interface().(Position), true
+}
+
+func TestParseBare(t *testing.T) {
+	var tests = []struct {
+		Text string
+		Node Node
+	}{
+		{
+			Text: "$string",
+			Node: &ParamNode{Name: "string", Bare: true},
+		},
+		{
+			Text: "$1",
+			Node: &ParamNode{Name: "1", Bare: true},
+		},
+		{
+			Text: "$10",
+			Node: &ListNode{
+				Nodes: []Node{
+					&ParamNode{Name: "1", Bare: true},
+					&TextNode{Value: "0"},
+				},
+			},
+		},
+		{
+			Text: "a$éb",
+			Node: &ListNode{
+				Nodes: []Node{
+					&TextNode{Value: "a"},
+					&ParamNode{Name: "éb", Bare: true},
+				},
+			},
+		},
+		{
+			Text: "$HOME/bin",
+			Node: &ListNode{
+				Nodes: []Node{
+					&ParamNode{Name: "HOME", Bare: true},
+					&TextNode{Value: "/bin"},
+				},
+			},
+		},
+		{
+			Text: "text $string_1.txt",
+			Node: &ListNode{
+				Nodes: []Node{
+					&TextNode{Value: "text "},
+					&ListNode{
+						Nodes: []Node{
+							&ParamNode{Name: "string_1", Bare: true},
+							&TextNode{Value: ".txt"},
+						},
+					},
+				},
+			},
+		},
+		{
+			Text: "$$string",
+			Node: &TextNode{Value: "$string"},
+		},
+		{
+			Text: "$ $- $",
+			Node: &TextNode{Value: "$ $- $"},
+		},
+		{
+			Text: "${string:-$default}",
+			Node: &DefaultNode{
+				Name:  "string",
+				Value: &ParamNode{Name: "default", Bare: true},
+				Colon: true,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Text, func(t *testing.T) {
+			got, err := ParseMode(test.Text, ParseBare)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			if diff := cmp.Diff(test.Node, got.Root, ignorePos); diff != "" {
+				t.Errorf(diff)
+			}
+		})
+	}
+}
+
+func TestParseLiteralDollars(t *testing.T) {
+	var tests = []struct {
+		Text string
+		Node Node
+	}{
+		{
+			Text: "$$",
+			Node: &TextNode{Value: "$$"},
+		},
+		{
+			Text: "$$x",
+			Node: &ListNode{
+				Nodes: []Node{
+					&TextNode{Value: "$"},
+					&ParamNode{Name: "x", Bare: true},
+				},
+			},
+		},
+		{
+			Text: "$${x}",
+			Node: &ListNode{
+				Nodes: []Node{
+					&TextNode{Value: "$"},
+					&ParamNode{Name: "x"},
+				},
+			},
+		},
+		{
+			Text: "${x:-$$}",
+			Node: &DefaultNode{
+				Name:  "x",
+				Value: &TextNode{Value: "$$"},
+				Colon: true,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Text, func(t *testing.T) {
+			got, err := ParseMode(test.Text, ParseBare|ParseLiteralDollars)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			if diff := cmp.Diff(test.Node, got.Root, ignorePos); diff != "" {
+				t.Errorf(diff)
+			}
+		})
+	}
+}
+
+func TestParsePipe(t *testing.T) {
+	funcs := map[string]bool{"base64": true, "trim": true}
+
+	var tests = []struct {
+		Text string
+		Node Node
+	}{
+		{
+			Text: "${string|base64}",
+			Node: &PipeNode{Name: "string", Funcs: []string{"base64"}},
+		},
+		{
+			Text: "${string|trim|base64}",
+			Node: &PipeNode{Name: "string", Funcs: []string{"trim", "base64"}},
+		},
+		{
+			Text: "${string:-${x|trim}}",
+			Node: &DefaultNode{
+				Name:  "string",
+				Value: &PipeNode{Name: "x", Funcs: []string{"trim"}},
+				Colon: true,
+			},
+		},
+		{
+			Text: "$${string|unknown}",
+			Node: &TextNode{Value: "${string|unknown}"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Text, func(t *testing.T) {
+			got, err := ParseFuncs(test.Text, 0, funcs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.Node, got.Root, ignorePos); diff != "" {
+				t.Errorf(diff)
+			}
+		})
+	}
+}
+
+func TestParseArithmetic(t *testing.T) {
+	var tests = []struct {
+		Text string
+		Node Node
+	}{
+		{
+			Text: "$((1+2))",
+			Node: &ArithNode{
+				Expr: &TextNode{Value: "1+2"},
+			},
+		},
+		{
+			Text: "$(( (x+1) * ${y:-2} ))",
+			Node: &ArithNode{
+				Expr: &ListNode{
+					Nodes: []Node{
+						&TextNode{Value: " (x+1) * "},
+						&DefaultNode{
+							Name:  "y",
+							Value: &TextNode{Value: "2"},
+							Colon: true,
+						},
+						&TextNode{Value: " "},
+					},
+				},
+			},
+		},
+		{
+			Text: "$(((${x}+1)*2))",
+			Node: &ArithNode{
+				Expr: &ListNode{
+					Nodes: []Node{
+						&TextNode{Value: "("},
+						&ParamNode{Name: "x"},
+						&TextNode{Value: "+1)*2"},
+					},
+				},
+			},
+		},
+		{
+			Text: "$(($x+$((1))))",
+			Node: &ArithNode{
+				Expr: &ListNode{
+					Nodes: []Node{
+						&ParamNode{Name: "x", Bare: true},
+						&TextNode{Value: "+"},
+						&ArithNode{
+							Expr: &TextNode{Value: "1"},
+						},
+					},
+				},
+			},
+		},
+		{
+			Text: "n=$((n))$$((n))",
+			Node: &ListNode{
+				Nodes: []Node{
+					&TextNode{Value: "n="},
+					&ListNode{
+						Nodes: []Node{
+							&ArithNode{
+								Expr: &TextNode{Value: "n"},
+							},
+							&TextNode{Value: "$((n))"},
+						},
+					},
+				},
+			},
+		},
+		{
+			Text: "${x:-$((1))}",
+			Node: &DefaultNode{
+				Name: "x",
+				Value: &ArithNode{
+					Expr: &TextNode{Value: "1"},
+				},
+				Colon: true,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Text, func(t *testing.T) {
+			got, err := ParseMode(test.Text, ParseArithmetic)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			if diff := cmp.Diff(test.Node, got.Root, ignorePos); diff != "" {
 				t.Errorf(diff)
 			}
 		})
 	}
+
+	// arithmetic expansions are literal text by default.
+	got, err := Parse("$((1+2))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(&TextNode{Value: "$((1+2))"}, got.Root); diff != "" {
+		t.Errorf(diff)
+	}
+}
+
+func TestParseError(t *testing.T) {
+	var tests = []struct {
+		Text string
+		Err  error
+		Line int
+		Col  int
+		Expr string
+	}{
+		{
+			Text: "${string",
+			Err:  ErrMissingClosingBrace,
+			Line: 1,
+			Col:  1,
+			Expr: "${string",
+		},
+		{
+			Text: "foo\nbar: $${x} ${string^}\nbaz: ${string\nqux",
+			Err:  ErrMissingClosingBrace,
+			Line: 3,
+			Col:  6,
+			Expr: "${string",
+		},
+		{
+			Text: "${}",
+			Err:  ErrParseVariableName,
+			Line: 1,
+			Col:  1,
+			Expr: "${}",
+		},
+		{
+			Text: "text ${string:-${nested^x}}",
+			Err:  ErrBadSubstitution,
+			Line: 1,
+			Col:  16,
+			Expr: "${nested^x",
+		},
+		{
+			Text: "${string:1+}",
+			Err:  ErrArithSyntax,
+			Line: 1,
+			Col:  1,
+			Expr: "${string:1+",
+		},
+		{
+			Text: "a $((1 2)) b",
+			Err:  ErrArithSyntax,
+			Line: 1,
+			Col:  3,
+			Expr: "$((1 2))",
+		},
+		{
+			Text: "a $((1+(2)",
+			Err:  ErrMissingClosingParen,
+			Line: 1,
+			Col:  3,
+			Expr: "$((1+(2)",
+		},
+		{
+			Text: "a ${string|base64} b",
+			Err:  ErrUnknownFunction,
+			Line: 1,
+			Col:  3,
+			Expr: "${string|base64",
+		},
+		{
+			Text: "${string|}",
+			Err:  ErrBadSubstitution,
+			Line: 1,
+			Col:  1,
+			Expr: "${string|}",
+		},
+		{
+			Text: "${string|trim x}",
+			Err:  ErrBadSubstitution,
+			Line: 1,
+			Col:  1,
+			Expr: "${string|trim ",
+		},
+		{
+			Text: "${string|trim",
+			Err:  ErrMissingClosingBrace,
+			Line: 1,
+			Col:  1,
+			Expr: "${string|trim",
+		},
+		{
+			Text: "$((1)",
+			Err:  ErrMissingClosingParen,
+			Line: 1,
+			Col:  1,
+			Expr: "$((1)",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Text, func(t *testing.T) {
+			_, err := ParseFuncs(test.Text, ParseArithmetic, map[string]bool{"trim": true})
+			if !errors.Is(err, test.Err) {
+				t.Fatalf("Want error %q, got %v", test.Err, err)
+			}
+			perr, ok := err.(*Error)
+			if !ok {
+				t.Fatalf("Want parse Error, got %T", err)
+			}
+			if perr.Line != test.Line || perr.Column != test.Col {
+				t.Errorf("Want error at %d:%d, got %d:%d", test.Line, test.Col, perr.Line, perr.Column)
+			}
+			if perr.Expr != test.Expr {
+				t.Errorf("Want error expression %q, got %q", test.Expr, perr.Expr)
+			}
+		})
+	}
 }
@@ -0,0 +1,225 @@
+package parse
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Print returns the template source of the tree. Text is escaped
+// where needed, such as $$ for a dollar sign that would otherwise
+// start a substitution, and \/ for a slash in a replace pattern, so
+// that parsing the result with the tree mode produces an equal tree,
+// ignoring source positions. Text that does not need escaping is
+// printed verbatim.
+//
+// Function arguments that cannot be escaped, such as a default value
+// containing a closing brace, are printed unchanged, as is text with
+// the ParseLiteralDollars mode, which has no escape for the dollar
+// sign.
+func Print(t *Tree) string {
+	p := printer{mode: t.Mode}
+	return p.print(t.Root, inText, "")
+}
+
+// printNode returns the template source of the node, escaping text
+// for all parser modes.
+func printNode(node Node) string {
+	p := printer{mode: ParseBare | ParseArithmetic}
+	return p.print(node, inText, "")
+}
+
+// context identifies how text is escaped.
+type context uint8
+
+const (
+	inText    context = iota // template text, where $ is escaped
+	inPattern                // replace pattern, where $, / and \ are escaped
+	inReplace                // replacement, where $ and \ are escaped
+	inWord                   // function argument, which is not escaped
+)
+
+// lookahead is the number of bytes of the following source needed to
+// decide whether text is escaped.
+const lookahead = utf8.UTFMax
+
+// printer prints nodes as template source.
+type printer struct {
+	mode Mode
+}
+
+// print returns the source of the node in context ctx, where next
+// is the beginning of the source that follows the node.
+func (p *printer) print(node Node, ctx context, next string) string {
+	switch n := node.(type) {
+	case *TextNode:
+		return p.text(n.Value, ctx, next)
+	case *ListNode:
+		// print the nodes in reverse order, so that each
+		// node knows the source that follows it.
+		nodes := flatten(n, nil)
+		parts := make([]string, len(nodes))
+		for i := len(nodes) - 1; i >= 0; i-- {
+			parts[i] = p.print(nodes[i], ctx, next)
+			next = peek(parts[i], next)
+		}
+		return strings.Join(parts, "")
+	case *ParamNode:
+		if n.Bare && p.mode&ParseBare != 0 && (isPositional(n.Name) || !startsIdent(next)) {
+			return "$" + n.Name
+		}
+		return "${" + n.Name + "}"
+	case *LenNode:
+		return "${#" + n.Name + "}"
+	case *CaseNode:
+		op := ","
+		if n.Upper {
+			op = "^"
+		}
+		if !n.First {
+			op += op
+		}
+		return "${" + n.Name + op + "}"
+	case *SubstrNode:
+		var length string
+		if n.Length != nil {
+			length = ":" + p.print(n.Length, inWord, "}")
+		}
+		offset := p.print(n.Offset, inWord, peek(length, "}"))
+		if offset != "" && strings.ContainsRune("-=?+", rune(offset[0])) {
+			// a space separates a negative offset from
+			// the ${var:-word} family of functions.
+			offset = " " + offset
+		}
+		return "${" + n.Name + ":" + offset + length + "}"
+	case *TrimNode:
+		op := "#"
+		if n.Suffix {
+			op = "%"
+		}
+		if n.Longest {
+			op += op
+		}
+		return "${" + n.Name + op + p.print(n.Pattern, inWord, "}") + "}"
+	case *ReplaceNode:
+		op := "/"
+		switch {
+		case n.All:
+			op = "//"
+		case n.Prefix:
+			op = "/#"
+		case n.Suffix:
+			op = "/%"
+		}
+		repl := p.print(n.Replacement, inReplace, "}")
+		if strings.HasPrefix(repl, "/") {
+			// the delimiter consumes consecutive slashes.
+			repl = `\` + repl
+		}
+		pattern := p.print(n.Pattern, inPattern, "/")
+		return "${" + n.Name + op + pattern + "/" + repl + "}"
+	case *DefaultNode:
+		op := "-"
+		if n.Assign {
+			op = "="
+		}
+		return "${" + n.Name + colon(n.Colon) + op + p.print(n.Value, inWord, "}") + "}"
+	case *RequiredNode:
+		return "${" + n.Name + colon(n.Colon) + "?" + p.print(n.Message, inWord, "}") + "}"
+	case *AlternateNode:
+		return "${" + n.Name + colon(n.Colon) + "+" + p.print(n.Value, inWord, "}") + "}"
+	case *PipeNode:
+		return "${" + n.Name + "|" + strings.Join(n.Funcs, "|") + "}"
+	case *ArithNode:
+		return "$((" + p.print(n.Expr, inWord, "))") + "))"
+	}
+	return ""
+}
+
+// text returns the source of the text in context ctx, where next is
+// the beginning of the source that follows the text.
+func (p *printer) text(s string, ctx context, next string) string {
+	if ctx == inWord {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		after := s[i+1:]
+		if len(after) < lookahead {
+			after += next
+		}
+		switch {
+		case c == '$' && p.mode&ParseLiteralDollars == 0 && p.startsSubst(after):
+			b.WriteString("$$")
+		case c == '/' && ctx == inPattern:
+			b.WriteString(`\/`)
+		case c == '\\' && ctx != inText && after != "" && (after[0] == '/' || after[0] == '\\'):
+			b.WriteString(`\\`)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// startsSubst reports whether a dollar sign followed by s would be
+// parsed as the start of a substitution, or as an escaped dollar.
+func (p *printer) startsSubst(s string) bool {
+	switch {
+	case s == "":
+		return false
+	case s[0] == '$', s[0] == '{':
+		return true
+	case p.mode&ParseArithmetic != 0 && strings.HasPrefix(s, "(("):
+		return true
+	case p.mode&ParseBare != 0:
+		return startsIdent(s)
+	}
+	return false
+}
+
+// flatten appends the nodes of the list, and of nested lists, to
+// nodes and returns the result.
+func flatten(list *ListNode, nodes []Node) []Node {
+	for _, n := range list.Nodes {
+		if l, ok := n.(*ListNode); ok {
+			nodes = flatten(l, nodes)
+		} else {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
+}
+
+// peek returns the beginning of the source s followed by next.
+func peek(s, next string) string {
+	if len(s) >= lookahead {
+		return s[:lookahead]
+	}
+	s += next
+	if len(s) > lookahead {
+		s = s[:lookahead]
+	}
+	return s
+}
+
+// startsIdent reports whether s starts with a variable name character.
+func startsIdent(s string) bool {
+	r, _ := utf8.DecodeRuneInString(s)
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// isPositional reports whether the name is a single digit positional
+// parameter, which ends an unbraced reference.
+func isPositional(name string) bool {
+	return len(name) == 1 && '0' <= name[0] && name[0] <= '9'
+}
+
+// colon returns the colon prefix of a function operator.
+func colon(ok bool) string {
+	if ok {
+		return ":"
+	}
+	return ""
+}
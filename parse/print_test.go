@@ -0,0 +1,133 @@
+package parse
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestPrint(t *testing.T) {
+	var tests = []struct {
+		Text string
+		Mode Mode
+		Want string
+	}{
+		{Text: "text ${string} text"},
+		{Text: "http://github.com"},
+		{Text: `\\.\pipe\pipename`},
+		{Text: "cost: $5, $ or $"},
+		{Text: "$${string}"},
+		{Text: "$${string}${string}"},
+		{Text: "a$$${string}"},
+		{Text: "$$$$", Want: "$$$"},
+		{Text: "$$string", Want: "$string"},
+		{Text: "$$string", Mode: ParseBare},
+		{Text: "$$((1))", Mode: ParseArithmetic},
+		{Text: "$((1))", Want: "$((1))"},
+		{Text: "a $ b $$$$ c", Want: "a $ b $$$ c"},
+		{Text: "$HOME/bin $1 $10", Mode: ParseBare},
+		{Text: "${#string} ${string,} ${string,,} ${string^} ${string^^}"},
+		{Text: "${string:1} ${string:1:2} ${string: -1} ${string:${a}:${#b}-1}"},
+		{Text: "${string#x} ${string##x*} ${string%x} ${string%%*x}"},
+		{Text: "${string-x} ${string:-x} ${string=x} ${string:=$x}"},
+		{Text: "${string?x} ${string:?} ${string+x} ${string:+${x}y}"},
+		{Text: "${string:-$$} ${string#$$}"},
+		{Text: `${string/\/position/length}`},
+		{Text: `${string/\/position\\/length}`},
+		{Text: `${string/position/\/length\\}`, Want: `${string/position/\/length\}`},
+		{Text: `${string/a\\b/c}`, Want: `${string/a\b/c}`},
+		{Text: "${string//$${x}/$${y}} ${string/#a/} ${string/%/b}"},
+		{Text: "$(( (1 + ${x}) * $y ))", Mode: ParseArithmetic | ParseBare},
+		{Text: "$$ $$x $${x} ${x:-$$}", Mode: ParseBare | ParseLiteralDollars},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Text, func(t *testing.T) {
+			tree, err := ParseMode(test.Text, test.Mode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := test.Want
+			if want == "" {
+				want = test.Text
+			}
+			got := Print(tree)
+			if got != want {
+				t.Errorf("Want %q printed as %q, got %q", test.Text, want, got)
+			}
+
+			printed, err := ParseMode(got, test.Mode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tree.Root, printed.Root, ignorePos); diff != "" {
+				t.Errorf(diff)
+			}
+		})
+	}
+}
+
+func TestPrintEscape(t *testing.T) {
+	var tests = []struct {
+		Node Node
+		Mode Mode
+		Want string
+	}{
+		{
+			Node: &ListNode{
+				Nodes: []Node{
+					&TextNode{Value: "${x} and $"},
+					&ParamNode{Name: "x"},
+				},
+			},
+			Want: "$${x} and $$${x}",
+		},
+		{
+			Node: &ListNode{
+				Nodes: []Node{
+					&ParamNode{Name: "HOME", Bare: true},
+					&TextNode{Value: "dir $PATH"},
+				},
+			},
+			Mode: ParseBare,
+			Want: "${HOME}dir $$PATH",
+		},
+		{
+			Node: &ParamNode{Name: "HOME", Bare: true},
+			Want: "${HOME}",
+		},
+		{
+			Node: &ReplaceNode{
+				Name:        "x",
+				Pattern:     &TextNode{Value: `a/b\`},
+				Replacement: &TextNode{Value: `c/d\/$`},
+				All:         true,
+			},
+			Want: `${x//a\/b\\/c/d\\/$}`,
+		},
+		{
+			Node: &ReplaceNode{
+				Name:        "x",
+				Pattern:     &TextNode{Value: "a"},
+				Replacement: &TextNode{Value: "/b"},
+			},
+			Want: `${x/a/\/b}`,
+		},
+		{
+			Node: &SubstrNode{
+				Name:   "x",
+				Offset: &TextNode{Value: "-2"},
+			},
+			Want: "${x: -2}",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.Want, func(t *testing.T) {
+			got := Print(&Tree{Root: test.Node, Mode: test.Mode})
+			if got != test.Want {
+				t.Errorf("Want node printed as %q, got %q", test.Want, got)
+			}
+		})
+	}
+}
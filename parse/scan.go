@@ -1,6 +1,7 @@
 package parse
 
 import (
+	"strings"
 	"unicode"
 	"unicode/utf8"
 )
@@ -24,6 +25,8 @@ const (
 	tokenLbrack
 	tokenRbrack
 	tokenQuote
+	tokenVar
+	tokenArith
 )
 
 // predefined mode bits to control recognition of tokens.
@@ -32,6 +35,8 @@ const (
 	scanLbrack
 	scanRbrack
 	scanEscape
+	scanVar
+	scanArith
 )
 
 // predefined mode bits to control escape tokens.
@@ -51,6 +56,7 @@ type scanner struct {
 	pos         int
 	start       int
 	width       int
+	skipped     []int // offsets of escape characters in the token
 	mode        byte
 	escapeChars byte
 
@@ -63,6 +69,7 @@ func (s *scanner) init(buf string) {
 	s.pos = 0
 	s.start = 0
 	s.width = 0
+	s.skipped = s.skipped[:0]
 	s.accept = nil
 }
 
@@ -83,12 +90,17 @@ func (s *scanner) unread() {
 	s.pos -= s.width
 }
 
-// skip skips over the curring unicode character in the buffer
-// by slicing and removing from the buffer.
+// skip records the current escape character as excluded from the
+// token string, and reads over the escaped character that follows.
 func (s *scanner) skip() {
-	l := s.buf[:s.pos-1]
-	r := s.buf[s.pos:]
-	s.buf = l + r
+	s.skipped = append(s.skipped, s.pos-1)
+	s.read()
+}
+
+// index returns the index of the current position in the token
+// string, not counting skipped characters.
+func (s *scanner) index() int {
+	return s.pos - s.start - len(s.skipped)
 }
 
 // peek returns the next unicode character in the buffer without
@@ -101,9 +113,33 @@ func (s *scanner) peek() rune {
 }
 
 // string returns the string corresponding to the most recently
-// scanned token. Valid after calling scan().
+// scanned token, without skipped characters. Valid after calling
+// scan().
 func (s *scanner) string() string {
-	return s.buf[s.start:s.pos]
+	if len(s.skipped) == 0 {
+		return s.buf[s.start:s.pos]
+	}
+	var b strings.Builder
+	b.Grow(s.pos - s.start - len(s.skipped))
+	i := s.start
+	for _, j := range s.skipped {
+		b.WriteString(s.buf[i:j])
+		i = j + 1
+	}
+	b.WriteString(s.buf[i:s.pos])
+	return b.String()
+}
+
+// position returns the byte offset in the source of the most
+// recently scanned token.
+func (s *scanner) position() int {
+	return s.start
+}
+
+// end returns the byte offset in the source of the scanner's
+// current position.
+func (s *scanner) end() int {
+	return s.pos
 }
 
 // tests if the bit exists for a given character bit
@@ -115,14 +151,19 @@ func (s *scanner) shouldEscape(character byte) bool {
 // returns it. It returns EOF at the end of the source.
 func (s *scanner) scan() token {
 	s.start = s.pos
+	s.skipped = s.skipped[:0]
 	r := s.read()
 	switch {
 	case r == eof:
 		return tokenEOF
 	case s.scanLbrack(r):
 		return tokenLbrack
+	case s.scanArith(r):
+		return tokenArith
 	case s.scanRbrack(r):
 		return tokenRbrack
+	case s.scanVar(r):
+		return tokenVar
 	case s.scanIdent(r):
 		return tokenIdent
 	}
@@ -137,7 +178,7 @@ func (s *scanner) scanIdent(r rune) bool {
 	}
 	if s.scanEscaped(r) {
 		s.skip()
-	} else if !s.accept(r, s.pos-s.start) {
+	} else if !s.accept(r, s.index()) {
 		return false
 	}
 loop:
@@ -151,12 +192,15 @@ loop:
 			s.unread()
 			s.unread()
 			break loop
+		case s.scanVarStart(r), s.scanArithStart(r):
+			s.unread()
+			break loop
 		}
 		if s.scanEscaped(r) {
 			s.skip()
 			continue
 		}
-		if !s.accept(r, s.pos-s.start) {
+		if !s.accept(r, s.index()) {
 			s.unread()
 			break loop
 		}
@@ -170,13 +214,62 @@ func (s *scanner) scanLbrack(r rune) bool {
 	if s.mode&scanLbrack == 0 {
 		return false
 	}
-	if r == '$' {
-		if s.read() == '{' {
+	if r == '$' && strings.HasPrefix(s.buf[s.pos:], "{") {
+		s.read()
+		return true
+	}
+	return false
+}
+
+// scanArith reads the next token or Unicode character from source
+// and returns true if the start of an arithmetic expansion $(( is
+// encountered.
+func (s *scanner) scanArith(r rune) bool {
+	if !s.scanArithStart(r) {
+		return false
+	}
+	s.pos += 2
+	return true
+}
+
+// scanArithStart returns true if the rune starts an arithmetic
+// expansion. It does not advance the scanner.
+func (s *scanner) scanArithStart(r rune) bool {
+	if s.mode&scanArith == 0 || r != '$' {
+		return false
+	}
+	return strings.HasPrefix(s.buf[s.pos:], "((")
+}
+
+// scanVar reads the next token or Unicode character from source
+// and returns true if an unbraced variable reference, such as $var
+// or $1, is encountered.
+func (s *scanner) scanVar(r rune) bool {
+	if !s.scanVarStart(r) {
+		return false
+	}
+	// positional parameters are limited to a single digit.
+	if r := s.read(); unicode.IsDigit(r) {
+		return true
+	}
+	for {
+		r := s.read()
+		if r == eof || !acceptIdent(r, s.pos-s.start) {
+			s.unread()
 			return true
 		}
-		s.unread()
 	}
-	return false
+}
+
+// scanVarStart returns true if the rune starts an unbraced variable
+// reference. It does not advance the scanner or change the width of
+// the current rune.
+func (s *scanner) scanVarStart(r rune) bool {
+	if s.mode&scanVar == 0 || r != '$' {
+		return false
+	}
+	n, _ := utf8.DecodeRuneInString(s.buf[s.pos:])
+	return n == '_' || unicode.IsLetter(n) || unicode.IsDigit(n)
 }
 
 // scanRbrack reads the next token or Unicode character from source
@@ -273,6 +366,18 @@ func acceptOneEqual(r rune, i int) bool {
 	return i == 1 && r == '='
 }
 
+func acceptOneDash(r rune, i int) bool {
+	return i == 1 && r == '-'
+}
+
+func acceptOneQuestion(r rune, i int) bool {
+	return i == 1 && r == '?'
+}
+
+func acceptOnePlus(r rune, i int) bool {
+	return i == 1 && r == '+'
+}
+
 func acceptOneColon(r rune, i int) bool {
 	return i == 1 && r == ':'
 }
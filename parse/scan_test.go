@@ -1 +1,32 @@
 package parse
+
+import "testing"
+
+func TestScanSkip(t *testing.T) {
+	var tests = []struct {
+		text  string
+		value string
+	}{
+		{`a$$b`, `a$b`},
+		{`$$a\\b\/c`, `$a\b/c`},
+		{`a\b\`, `a\b\`},
+	}
+	for _, test := range tests {
+		var s scanner
+		s.init(test.text)
+		s.accept = acceptRune
+		s.mode = scanIdent | scanEscape
+		s.escapeChars = escapeAll
+		if tok := s.scan(); tok != tokenIdent {
+			t.Errorf("Want %q scanned as an identifier, got token %d", test.text, tok)
+			continue
+		}
+		if got := s.string(); got != test.value {
+			t.Errorf("Want %q scanned as %q, got %q", test.text, test.value, got)
+		}
+		// positions are offsets in the source.
+		if s.position() != 0 || s.end() != len(test.text) {
+			t.Errorf("Want %q scanned from 0 to %d, got %d to %d", test.text, len(test.text), s.position(), s.end())
+		}
+	}
+}
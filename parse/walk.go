@@ -0,0 +1,60 @@
+package parse
+
+// Visitor is invoked by Walk for each node encountered. If the
+// result visitor w is not nil, Walk visits each of the children
+// of node with the visitor w, followed by a call of w.Visit(nil).
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk traverses the tree in depth-first order. It starts by calling
+// v.Visit(node); node must not be nil.
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
+	switch n := node.(type) {
+	case *ListNode:
+		for _, c := range n.Nodes {
+			Walk(v, c)
+		}
+	case *SubstrNode:
+		Walk(v, n.Offset)
+		if n.Length != nil {
+			Walk(v, n.Length)
+		}
+	case *TrimNode:
+		Walk(v, n.Pattern)
+	case *ReplaceNode:
+		Walk(v, n.Pattern)
+		Walk(v, n.Replacement)
+	case *DefaultNode:
+		Walk(v, n.Value)
+	case *RequiredNode:
+		Walk(v, n.Message)
+	case *AlternateNode:
+		Walk(v, n.Value)
+	case *ArithNode:
+		Walk(v, n.Expr)
+	}
+
+	v.Visit(nil)
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses the tree in depth-first order. It starts by
+// calling f(node); node must not be nil. If f returns true, Inspect
+// invokes f recursively for each of the children of node, followed
+// by a call of f(nil).
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
@@ -0,0 +1,59 @@
+package parse
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestInspect(t *testing.T) {
+	tree, err := Parse("text ${a} ${b:-${c,,}} ${d//${e}/f}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	Inspect(tree.Root, func(node Node) bool {
+		switch node := node.(type) {
+		case *ParamNode:
+			got = append(got, node.Name)
+		case *DefaultNode:
+			got = append(got, node.Name)
+		case *CaseNode:
+			got = append(got, node.Name)
+		case *ReplaceNode:
+			got = append(got, node.Name)
+		}
+		return true
+	})
+
+	want := []string{"a", "b", "c", "d", "e"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf(diff)
+	}
+}
+
+func TestInspectSkip(t *testing.T) {
+	tree, err := Parse("${a} ${b:-${c}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	Inspect(tree.Root, func(node Node) bool {
+		switch node := node.(type) {
+		case *ParamNode:
+			got = append(got, node.Name)
+			return false
+		case *DefaultNode:
+			got = append(got, node.Name)
+			return false
+		}
+		return true
+	})
+
+	want := []string{"a", "b"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf(diff)
+	}
+}
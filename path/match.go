@@ -2,6 +2,10 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
+// Package path implements shell file name pattern matching.
+//
+// Deprecated: the pattern package implements bash pattern matching
+// without file path semantics, and is used for parameter expansion.
 package path
 
 import (
@@ -20,7 +24,7 @@ var ErrBadPattern = errors.New("syntax error in pattern")
 //	term:
 //		'*'         matches any sequence of non-/ characters
 //		'?'         matches any single non-/ character
-//		'[' [ '^' ] { character-range } ']'
+//		'[' [ '^' | '!' ] { character-range } ']'
 //		            character class (must be non-empty)
 //		c           matches character c (c != '*', '?', '\\', '[')
 //		'\\' c      matches character c
@@ -127,7 +131,7 @@ func matchChunk(chunk, s string) (rest string, ok bool, err error) {
 			chunk = chunk[1:]
 			// possibly negated
 			notNegated := true
-			if len(chunk) > 0 && chunk[0] == '^' {
+			if len(chunk) > 0 && (chunk[0] == '^' || chunk[0] == '!') {
 				notNegated = false
 				chunk = chunk[1:]
 			}
@@ -0,0 +1,164 @@
+package pattern
+
+import "unicode/utf8"
+
+// ShortestPrefix returns the end offset of the shortest prefix of s
+// that matches the pattern, or -1 if no prefix matches.
+func (p *Pattern) ShortestPrefix(s string) int {
+	return p.matchPrefix(s, false)
+}
+
+// LongestPrefix returns the end offset of the longest prefix of s
+// that matches the pattern, or -1 if no prefix matches.
+func (p *Pattern) LongestPrefix(s string) int {
+	return p.matchPrefix(s, true)
+}
+
+// ShortestSuffix returns the start offset of the shortest suffix of
+// s that matches the pattern, or -1 if no suffix matches.
+func (p *Pattern) ShortestSuffix(s string) int {
+	return p.matchSuffix(s, false)
+}
+
+// LongestSuffix returns the start offset of the longest suffix of s
+// that matches the pattern, or -1 if no suffix matches.
+func (p *Pattern) LongestSuffix(s string) int {
+	return p.matchSuffix(s, true)
+}
+
+// matchPrefix returns the end offset of the shortest or longest
+// prefix of s that matches the pattern, or -1. The input is scanned
+// once, recording each offset at which the machine is in the
+// matching state.
+func (p *Pattern) matchPrefix(s string, longest bool) int {
+	m := p.machine(s)
+	m.add(m.clist, 0)
+	end := -1
+	if m.matched() {
+		end = 0
+	}
+	for (end == -1 || longest) && m.pos < len(s) && m.running() {
+		m.step()
+		if m.matched() {
+			end = m.pos
+		}
+	}
+	m.input = ""
+	p.machines.Put(m)
+	return end
+}
+
+// matchSuffix returns the start offset of the shortest or longest
+// suffix of s that matches the pattern, or -1. The input is scanned
+// once, starting a thread at each offset and recording the offset
+// at which each thread started. Threads in the same state match the
+// same suffixes, so only the thread with the earliest start offset,
+// for the longest suffix, or the latest start offset, for the
+// shortest suffix, is kept. The thread list is ordered by start
+// offset, so the first thread added for an instruction is kept.
+func (p *Pattern) matchSuffix(s string, longest bool) int {
+	if p.hasNot {
+		// threads in a !(pattern-list) operator depend on the
+		// offset at which they entered the operator, so each
+		// suffix is matched separately.
+		return p.matchEachSuffix(s, longest)
+	}
+
+	m := &suffixMachine{
+		prog:   p.prog,
+		clist:  newSparse(len(p.prog)),
+		nlist:  newSparse(len(p.prog)),
+		cstart: make([]int, len(p.prog)),
+		nstart: make([]int, len(p.prog)),
+	}
+	m.add(m.clist, m.cstart, 0, 0)
+	for pos := 0; pos < len(s); {
+		r, n := utf8.DecodeRuneInString(s[pos:])
+		pos += n
+		m.nlist.clear()
+		if !longest {
+			m.add(m.nlist, m.nstart, 0, pos)
+		}
+		m.step(r)
+		if longest {
+			m.add(m.nlist, m.nstart, 0, pos)
+		}
+		m.clist, m.nlist = m.nlist, m.clist
+		m.cstart, m.nstart = m.nstart, m.cstart
+	}
+	for _, pc := range m.clist.dense {
+		if m.prog[pc].op == opMatch {
+			return m.cstart[pc]
+		}
+	}
+	return -1
+}
+
+// matchEachSuffix matches each suffix of s, from the longest or from
+// the shortest, and returns the start offset of the first match, or
+// -1 if no suffix matches.
+func (p *Pattern) matchEachSuffix(s string, longest bool) int {
+	for i := 0; i <= len(s); i++ {
+		j := i
+		if !longest {
+			j = len(s) - i
+		}
+		if isRuneStart(s, j) && p.Match(s[j:]) {
+			return j
+		}
+	}
+	return -1
+}
+
+// isRuneStart reports whether the offset i in s is the start of a
+// rune, or the end of the string.
+func isRuneStart(s string, i int) bool {
+	return i == len(s) || utf8.RuneStart(s[i])
+}
+
+// suffixMachine simulates the instructions of a compiled pattern
+// without the !(pattern-list) operator, recording the offset at
+// which each thread started.
+type suffixMachine struct {
+	prog           []inst
+	clist, nlist   *sparse
+	cstart, nstart []int // start offset of the thread at each instruction
+}
+
+// add adds the instruction to the list, following jumps and splits.
+// An instruction already in the list keeps its start offset.
+func (m *suffixMachine) add(l *sparse, starts []int, pc, start int) {
+	if l.contains(pc) {
+		return
+	}
+	l.insert(pc)
+	starts[pc] = start
+	switch i := m.prog[pc]; i.op {
+	case opJmp:
+		m.add(l, starts, i.x, start)
+	case opSplit:
+		m.add(l, starts, i.x, start)
+		m.add(l, starts, i.y, start)
+	}
+}
+
+// step advances the threads in the current list over the rune.
+func (m *suffixMachine) step(r rune) {
+	for _, pc := range m.clist.dense {
+		i := m.prog[pc]
+		switch i.op {
+		case opRune:
+			if r != i.r {
+				continue
+			}
+		case opAny:
+		case opClass:
+			if !i.class.matches(r) {
+				continue
+			}
+		default:
+			continue
+		}
+		m.add(m.nlist, m.nstart, pc+1, m.cstart[pc])
+	}
+}
@@ -0,0 +1,97 @@
+package pattern
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestPrefixSuffix(t *testing.T) {
+	var tests = []struct {
+		pattern  string
+		s        string
+		shortest int // shortest prefix end
+		longest  int // longest prefix end
+		sshort   int // shortest suffix start
+		slong    int // longest suffix start
+	}{
+		{"*", "abc", 0, 3, 3, 0},
+		{"a*", "abca", 1, 4, 3, 0},
+		{"a*", "bcb", -1, -1, -1, -1},
+		{"*a", "abca", 1, 4, 3, 0},
+		{"[a-z]*", "ab1c", 1, 4, 3, 0},
+		{"?", "éa", 2, 2, 2, 2},
+		{"b", "abc", -1, -1, -1, -1},
+		{"", "abc", 0, 0, 3, 3},
+		{"*.", "a.b.", 2, 4, 3, 0},
+	}
+	for _, test := range tests {
+		p := MustCompile(test.pattern)
+		got := []int{
+			p.ShortestPrefix(test.s),
+			p.LongestPrefix(test.s),
+			p.ShortestSuffix(test.s),
+			p.LongestSuffix(test.s),
+		}
+		want := []int{test.shortest, test.longest, test.sshort, test.slong}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("Want pattern %q offsets in %q %v, got %v", test.pattern, test.s, want, got)
+				break
+			}
+		}
+	}
+}
+
+// TestPrefixSuffixRandom verifies that the one pass prefix and suffix
+// matches agree with matching each prefix and suffix separately.
+func TestPrefixSuffixRandom(t *testing.T) {
+	tokens := []string{
+		"a", "b", "é", "?", "*", "[ab]", "[!a]", "\\*",
+		"@(a|b)", "*(a)", "+(ab|b)", "?(é)", "!(a)", "!(*b)",
+	}
+	chars := []string{"a", "b", "é", "*", "c"}
+
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 5000; i++ {
+		var pat, s strings.Builder
+		for j := r.Intn(4); j >= 0; j-- {
+			pat.WriteString(tokens[r.Intn(len(tokens))])
+		}
+		for j := r.Intn(8); j > 0; j-- {
+			s.WriteString(chars[r.Intn(len(chars))])
+		}
+
+		p, _ := CompileMode(pat.String(), ExtGlob)
+		want := []int{-1, -1, -1, -1}
+		for j := 0; j <= s.Len(); j++ {
+			if !isRuneStart(s.String(), j) {
+				continue
+			}
+			if p.Match(s.String()[:j]) {
+				if want[0] == -1 {
+					want[0] = j
+				}
+				want[1] = j
+			}
+			if p.Match(s.String()[j:]) {
+				if want[3] == -1 {
+					want[3] = j
+				}
+				want[2] = j
+			}
+		}
+		got := []int{
+			p.ShortestPrefix(s.String()),
+			p.LongestPrefix(s.String()),
+			p.ShortestSuffix(s.String()),
+			p.LongestSuffix(s.String()),
+		}
+		for j := range want {
+			if got[j] != want[j] {
+				t.Errorf("Want pattern %q offsets in %q %v, got %v", pat.String(), s.String(), want, got)
+				break
+			}
+		}
+	}
+}
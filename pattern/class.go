@@ -0,0 +1,104 @@
+package pattern
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// class represents a bracket expression.
+type class struct {
+	negate bool
+	ranges []rune // pairs of lo, hi
+	funcs  []func(rune) bool
+}
+
+// matches reports whether the rune matches the bracket expression.
+func (c *class) matches(r rune) bool {
+	match := false
+	for i := 0; i < len(c.ranges) && !match; i += 2 {
+		match = c.ranges[i] <= r && r <= c.ranges[i+1]
+	}
+	for i := 0; i < len(c.funcs) && !match; i++ {
+		match = c.funcs[i](r)
+	}
+	return match != c.negate
+}
+
+// posix character classes.
+var classes = map[string]func(rune) bool{
+	"alnum": func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) },
+	"alpha": unicode.IsLetter,
+	"ascii": func(r rune) bool { return r <= unicode.MaxASCII },
+	"blank": func(r rune) bool { return r == ' ' || r == '\t' },
+	"cntrl": unicode.IsControl,
+	"digit": func(r rune) bool { return '0' <= r && r <= '9' },
+	"graph": func(r rune) bool { return unicode.IsGraphic(r) && !unicode.IsSpace(r) },
+	"lower": unicode.IsLower,
+	"print": unicode.IsPrint,
+	"punct": unicode.IsPunct,
+	"space": unicode.IsSpace,
+	"upper": unicode.IsUpper,
+	"word":  func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' },
+	"xdigit": func(r rune) bool {
+		return '0' <= r && r <= '9' || 'a' <= r && r <= 'f' || 'A' <= r && r <= 'F'
+	},
+}
+
+// none matches no characters, and is used for unknown classes.
+func none(rune) bool { return false }
+
+// parseClass parses the bracket expression following the opening
+// bracket. It returns the remaining pattern, and false if the pattern
+// does not contain a complete bracket expression.
+func parseClass(pattern string) (*class, string, bool) {
+	c := new(class)
+	if strings.HasPrefix(pattern, "!") || strings.HasPrefix(pattern, "^") {
+		c.negate = true
+		pattern = pattern[1:]
+	}
+	for first := true; ; first = false {
+		if len(pattern) == 0 {
+			return nil, "", false
+		}
+		if pattern[0] == ']' && !first {
+			return c, pattern[1:], true
+		}
+		if strings.HasPrefix(pattern, "[:") {
+			if i := strings.Index(pattern[2:], ":]"); i != -1 {
+				fn, ok := classes[pattern[2:2+i]]
+				if !ok {
+					fn = none
+				}
+				c.funcs = append(c.funcs, fn)
+				pattern = pattern[2+i+2:]
+				continue
+			}
+		}
+		lo, rest := parseRune(pattern)
+		pattern = rest
+		hi := lo
+		if len(pattern) > 1 && pattern[0] == '-' && pattern[1] != ']' {
+			hi, pattern = parseRune(pattern[1:])
+		}
+		c.ranges = append(c.ranges, lo, hi)
+	}
+}
+
+// parseRune parses a possibly escaped character, or a collating
+// symbol or equivalence class, in a bracket expression.
+func parseRune(pattern string) (rune, string) {
+	for _, delim := range []string{".", "="} {
+		if strings.HasPrefix(pattern, "["+delim) {
+			if i := strings.Index(pattern[2:], delim+"]"); i > 0 {
+				r, _ := utf8.DecodeRuneInString(pattern[2:])
+				return r, pattern[2+i+2:]
+			}
+		}
+	}
+	if pattern[0] == '\\' && len(pattern) > 1 {
+		pattern = pattern[1:]
+	}
+	r, n := utf8.DecodeRuneInString(pattern)
+	return r, pattern[n:]
+}
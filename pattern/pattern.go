@@ -0,0 +1,431 @@
+// Package pattern implements bash pattern matching, as used by the
+// parameter expansion functions that remove or replace a pattern.
+//
+// The pattern syntax is:
+//
+//	pattern:
+//		{ term }
+//	term:
+//		'*'         matches any sequence of characters
+//		'?'         matches any single character
+//		'[' [ '!' | '^' ] { bracket-term } ']'
+//		            bracket expression (must be non-empty)
+//		c           matches character c (c != '*', '?', '\\', '[')
+//		'\\' c      matches character c
+//
+//	bracket-term:
+//		c           matches character c
+//		'\\' c      matches character c
+//		lo '-' hi   matches character c for lo <= c <= hi
+//		'[:' class ':]'
+//		            matches a character in the posix character class,
+//		            such as alpha, digit or space
+//		'[=' c '=]' matches character c
+//		'[.' c '.]' matches character c
+//
+// Unlike path.Match, the '*' and '?' terms match any character,
+// including '/'. A '[' that does not start a complete bracket
+// expression, and a trailing '\\', match themselves, like bash.
+//
+// When compiled with the ExtGlob mode, the extended pattern matching
+// operators of bash are also recognized, where pattern-list is a list
+// of one or more patterns separated by '|':
+//
+//	'?(' pattern-list ')'  matches zero or one occurrence of the patterns
+//	'*(' pattern-list ')'  matches zero or more occurrences of the patterns
+//	'+(' pattern-list ')'  matches one or more occurrences of the patterns
+//	'@(' pattern-list ')'  matches one of the patterns
+//	'!(' pattern-list ')'  matches anything except one of the patterns
+package pattern
+
+import (
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Mode values are a set of flags (or 0) that control pattern syntax.
+type Mode uint
+
+const (
+	// ExtGlob enables the extended pattern matching operators,
+	// like the bash extglob shell option.
+	ExtGlob Mode = 1 << iota
+)
+
+// Pattern is the compiled representation of a pattern. A Pattern is
+// safe for concurrent use by multiple goroutines.
+type Pattern struct {
+	prog   []inst
+	hasNot bool // prog contains the !(pattern-list) operator
+
+	// machines reused by Match, to avoid allocating a machine
+	// for each match.
+	machines sync.Pool
+}
+
+// opcode identifies the instruction type.
+type opcode uint8
+
+const (
+	opRune  opcode = iota // match a single rune
+	opAny                 // match any rune
+	opClass               // match a rune in a bracket expression
+	opSplit               // continue at x and y
+	opJmp                 // continue at x
+	opNot                 // match any string not matching sub
+	opMatch               // pattern matched
+)
+
+// inst is a single instruction in a compiled pattern.
+type inst struct {
+	op    opcode
+	r     rune
+	class *class
+	sub   *Pattern
+	x, y  int
+}
+
+// Compile parses a pattern and returns, if successful, a Pattern
+// that can be used to match strings.
+func Compile(pattern string) (*Pattern, error) {
+	return CompileMode(pattern, 0)
+}
+
+// CompileMode parses a pattern using the specified mode and returns,
+// if successful, a Pattern that can be used to match strings.
+func CompileMode(pattern string, mode Mode) (*Pattern, error) {
+	c := &compiler{mode: mode}
+	c.compile(pattern)
+	c.emit(inst{op: opMatch})
+	p := &Pattern{prog: c.prog}
+	for _, i := range p.prog {
+		p.hasNot = p.hasNot || i.op == opNot
+	}
+	return p, nil
+}
+
+// MustCompile is like Compile but panics if the pattern cannot be
+// parsed.
+func MustCompile(pattern string) *Pattern {
+	p, err := Compile(pattern)
+	if err != nil {
+		panic(`pattern: Compile(` + pattern + `): ` + err.Error())
+	}
+	return p
+}
+
+// Match reports whether the string s matches the pattern. The pattern
+// must match all of s, not just a substring.
+func Match(pattern, s string) (bool, error) {
+	p, err := Compile(pattern)
+	if err != nil {
+		return false, err
+	}
+	return p.Match(s), nil
+}
+
+// Match reports whether the string s matches the pattern. The pattern
+// must match all of s, not just a substring.
+func (p *Pattern) Match(s string) bool {
+	m := p.machine(s)
+	m.add(m.clist, 0)
+	for m.pos < len(s) && m.running() {
+		m.step()
+	}
+	ok := m.matched()
+	m.input = ""
+	p.machines.Put(m)
+	return ok
+}
+
+// machine returns a machine for the input, reusing a machine from
+// a previous match if one is available.
+func (p *Pattern) machine(input string) *machine {
+	m, ok := p.machines.Get().(*machine)
+	if !ok {
+		return newMachine(p.prog, input)
+	}
+	m.clist.clear()
+	m.nlist.clear()
+	m.input = input
+	m.pos = 0
+	m.nots = m.nots[:0]
+	return m
+}
+
+// compiler compiles a pattern to a list of instructions.
+type compiler struct {
+	prog []inst
+	mode Mode
+}
+
+// emit appends the instruction and returns its index.
+func (c *compiler) emit(i inst) int {
+	c.prog = append(c.prog, i)
+	return len(c.prog) - 1
+}
+
+func (c *compiler) compile(pattern string) {
+	for len(pattern) != 0 {
+		r, n := utf8.DecodeRuneInString(pattern)
+		pattern = pattern[n:]
+		if c.mode&ExtGlob != 0 && strings.ContainsRune("?*+@!", r) && strings.HasPrefix(pattern, "(") {
+			if list, rest, ok := parseList(pattern[1:]); ok {
+				c.compileExt(r, list)
+				pattern = rest
+				continue
+			}
+		}
+		switch r {
+		case '*':
+			// L0: split L1, L2
+			// L1: any; jmp L0
+			// L2: ...
+			split := c.emit(inst{op: opSplit})
+			c.emit(inst{op: opAny})
+			c.emit(inst{op: opJmp, x: split})
+			c.prog[split].x = split + 1
+			c.prog[split].y = len(c.prog)
+		case '?':
+			c.emit(inst{op: opAny})
+		case '[':
+			cls, rest, ok := parseClass(pattern)
+			if !ok {
+				c.emit(inst{op: opRune, r: r})
+				continue
+			}
+			c.emit(inst{op: opClass, class: cls})
+			pattern = rest
+		case '\\':
+			if len(pattern) != 0 {
+				r, n = utf8.DecodeRuneInString(pattern)
+				pattern = pattern[n:]
+			}
+			c.emit(inst{op: opRune, r: r})
+		default:
+			c.emit(inst{op: opRune, r: r})
+		}
+	}
+}
+
+// compileExt compiles the extended pattern matching operator.
+func (c *compiler) compileExt(op rune, list []string) {
+	switch op {
+	case '@':
+		c.alternate(list)
+	case '?':
+		// L0: split L1, L2
+		// L1: pattern-list
+		// L2: ...
+		split := c.emit(inst{op: opSplit})
+		c.alternate(list)
+		c.prog[split].x = split + 1
+		c.prog[split].y = len(c.prog)
+	case '*':
+		// L0: split L1, L2
+		// L1: pattern-list; jmp L0
+		// L2: ...
+		split := c.emit(inst{op: opSplit})
+		c.alternate(list)
+		c.emit(inst{op: opJmp, x: split})
+		c.prog[split].x = split + 1
+		c.prog[split].y = len(c.prog)
+	case '+':
+		// L0: pattern-list
+		// L1: split L0, L2
+		// L2: ...
+		start := len(c.prog)
+		c.alternate(list)
+		c.emit(inst{op: opSplit, x: start, y: len(c.prog) + 1})
+	case '!':
+		sub := &compiler{mode: c.mode}
+		sub.alternate(list)
+		sub.emit(inst{op: opMatch})
+		c.emit(inst{op: opNot, sub: &Pattern{prog: sub.prog}})
+	}
+}
+
+// alternate compiles a list of alternative patterns.
+func (c *compiler) alternate(list []string) {
+	var jmps []int
+	for i, pattern := range list {
+		if i == len(list)-1 {
+			c.compile(pattern)
+			break
+		}
+		split := c.emit(inst{op: opSplit})
+		c.prog[split].x = split + 1
+		c.compile(pattern)
+		jmps = append(jmps, c.emit(inst{op: opJmp}))
+		c.prog[split].y = len(c.prog)
+	}
+	for _, jmp := range jmps {
+		c.prog[jmp].x = len(c.prog)
+	}
+}
+
+// parseList parses the pattern-list following the opening parenthesis
+// of an extended pattern matching operator. It returns the remaining
+// pattern, and false if the closing parenthesis is missing.
+func parseList(pattern string) (list []string, rest string, ok bool) {
+	depth, start := 0, 0
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '\\':
+			i++
+		case '[':
+			if _, rest, ok := parseClass(pattern[i+1:]); ok {
+				i = len(pattern) - len(rest) - 1
+			}
+		case '(':
+			depth++
+		case ')':
+			if depth == 0 {
+				list = append(list, pattern[start:i])
+				return list, pattern[i+1:], true
+			}
+			depth--
+		case '|':
+			if depth == 0 {
+				list = append(list, pattern[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return nil, "", false
+}
+
+// sparse is a sparse set of instruction indexes.
+type sparse struct {
+	dense  []int
+	sparse []int
+}
+
+func newSparse(n int) *sparse {
+	return &sparse{
+		dense:  make([]int, 0, n),
+		sparse: make([]int, n),
+	}
+}
+
+func (s *sparse) contains(i int) bool {
+	j := s.sparse[i]
+	return j < len(s.dense) && s.dense[j] == i
+}
+
+func (s *sparse) insert(i int) {
+	s.sparse[i] = len(s.dense)
+	s.dense = append(s.dense, i)
+}
+
+func (s *sparse) clear() {
+	s.dense = s.dense[:0]
+}
+
+// machine simulates the instructions of a compiled pattern on
+// an input string, one rune at a time.
+type machine struct {
+	prog         []inst
+	clist, nlist *sparse
+
+	input string
+	pos   int // byte offset of the next rune in the input
+
+	// threads waiting in a !(pattern-list) operator, and the
+	// offsets at which they entered the operator.
+	nots []notThread
+}
+
+type notThread struct {
+	pc    int
+	start int
+}
+
+func newMachine(prog []inst, input string) *machine {
+	return &machine{
+		prog:  prog,
+		clist: newSparse(len(prog)),
+		nlist: newSparse(len(prog)),
+		input: input,
+	}
+}
+
+// add adds the instruction to the list, following jumps and splits.
+func (m *machine) add(l *sparse, pc int) {
+	if l.contains(pc) {
+		return
+	}
+	l.insert(pc)
+	switch i := m.prog[pc]; i.op {
+	case opJmp:
+		m.add(l, i.x)
+	case opSplit:
+		m.add(l, i.x)
+		m.add(l, i.y)
+	case opNot:
+		m.addNot(l, pc)
+	}
+}
+
+// addNot starts a thread in a !(pattern-list) operator at the
+// current offset. The thread matches the empty string unless the
+// pattern-list matches the empty string.
+func (m *machine) addNot(l *sparse, pc int) {
+	for _, t := range m.nots {
+		if t.pc == pc && t.start == m.pos {
+			return
+		}
+	}
+	m.nots = append(m.nots, notThread{pc: pc, start: m.pos})
+	if !m.prog[pc].sub.Match("") {
+		m.add(l, pc+1)
+	}
+}
+
+// running reports whether the machine has any threads that can
+// consume more input.
+func (m *machine) running() bool {
+	return len(m.clist.dense) != 0 || len(m.nots) != 0
+}
+
+// step advances the machine by one rune.
+func (m *machine) step() {
+	r, n := utf8.DecodeRuneInString(m.input[m.pos:])
+	m.pos += n
+	m.nlist.clear()
+	for _, pc := range m.clist.dense {
+		i := m.prog[pc]
+		switch i.op {
+		case opRune:
+			if r == i.r {
+				m.add(m.nlist, pc+1)
+			}
+		case opAny:
+			m.add(m.nlist, pc+1)
+		case opClass:
+			if i.class.matches(r) {
+				m.add(m.nlist, pc+1)
+			}
+		}
+	}
+	// threads in a !(pattern-list) operator continue when the
+	// input consumed by the operator does not match the list.
+	for j, n := 0, len(m.nots); j < n; j++ {
+		t := m.nots[j]
+		if !m.prog[t.pc].sub.Match(m.input[t.start:m.pos]) {
+			m.add(m.nlist, t.pc+1)
+		}
+	}
+	m.clist, m.nlist = m.nlist, m.clist
+}
+
+// matched reports whether the machine is in the matching state.
+func (m *machine) matched() bool {
+	for _, pc := range m.clist.dense {
+		if m.prog[pc].op == opMatch {
+			return true
+		}
+	}
+	return false
+}
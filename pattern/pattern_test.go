@@ -0,0 +1,140 @@
+package pattern
+
+import "testing"
+
+// test cases verified with bash [[ s == pattern ]] in a UTF-8 locale.
+var tests = []struct {
+	pattern string
+	s       string
+	match   bool
+}{
+	{"*", "", true},
+	{"*", "abc", true},
+	{"*", "a/b", true},
+	{"?", "a", true},
+	{"?", "", false},
+	{"?", "é", true},
+	{"a?c", "a/c", true},
+	{"a*c", "abc", true},
+	{"a*c", "ab", false},
+	{"a*b*c", "aXbYbZc", true},
+	{"*.txt", "file.tar.txt", true},
+	{"[abc]", "b", true},
+	{"[abc]", "d", false},
+	{"[!abc]", "d", true},
+	{"[^abc]", "a", false},
+	{"[a-z]", "m", true},
+	{"[a-z]", "M", false},
+	{"[z-a]", "m", false},
+	{"[]]", "]", true},
+	{"[]a]", "a", true},
+	{"[!]]", "a", true},
+	{"[]-]", "-", true},
+	{"[a-]", "-", true},
+	{"[[:digit:]]", "5", true},
+	{"[[:digit:]]", "x", false},
+	{"[[:alpha:][:digit:]]", "5", true},
+	{"[[:upper:]]", "A", true},
+	{"[[:upper:]]", "a", false},
+	{"[[:space:]]", " ", true},
+	{"[[:foo:]]", "a", false},
+	{"[[:xdigit:]]", "F", true},
+	{"[[:punct:]]", "!", true},
+	{"[[.-.]]", "-", true},
+	{"[[=a=]]", "a", true},
+	{"[", "[", true},
+	{"[a", "[a", true},
+	{"a[", "a[", true},
+	{`\*`, "*", true},
+	{`\*`, "a", false},
+	{`\\`, `\`, true},
+	{`a\`, `a\`, true},
+	{`[\]]`, "]", true},
+	{"*[[:digit:]]", "v123", true},
+	{"[[:digit:]]*", "1.2.3", true},
+	{"[a-c]*[0-9]", "abc9", true},
+}
+
+func TestMatch(t *testing.T) {
+	for _, test := range tests {
+		got, err := Match(test.pattern, test.s)
+		if err != nil {
+			t.Errorf("Unexpected error for pattern %q: %v", test.pattern, err)
+		}
+		if got != test.match {
+			t.Errorf("Want Match(%q, %q) == %v, got %v", test.pattern, test.s, test.match, got)
+		}
+	}
+}
+
+// test cases verified with bash -O extglob [[ s == pattern ]].
+var extTests = []struct {
+	pattern string
+	s       string
+	match   bool
+}{
+	{"?(a|b)", "", true},
+	{"?(a|b)", "a", true},
+	{"?(a|b)", "ab", false},
+	{"*(a|b)", "abba", true},
+	{"*(a|b)", "abc", false},
+	{"+(a|b)", "", false},
+	{"+(a|b)", "bab", true},
+	{"@(a|b)", "a", true},
+	{"@(a|b)", "ab", false},
+	{"!(a|b)", "a", false},
+	{"!(a|b)", "c", true},
+	{"!(a|b)", "ab", true},
+	{"!(a|b)", "", true},
+	{"!(*.txt)", "file.txt", false},
+	{"!(*.txt)", "file.go", true},
+	{"*.!(txt)", "file.go", true},
+	{"*.!(txt)", "file.txt", false},
+	{"+([0-9]).+([0-9])", "12.345", true},
+	{"+([0-9])", "12a", false},
+	{"@(foo|bar)-+([[:digit:]])", "bar-42", true},
+	{"a*(b|c)d", "abcbd", true},
+	{"a?(x)b", "ab", true},
+	{"*(a|)", "aaa", true},
+	{"!(foo)bar", "foobar", false},
+	{"!(foo)bar", "fobar", true},
+	{"*([0-9])", "", true},
+	{"@(a|b|abc)c", "abcc", true},
+	{`x@(a|b\|c)`, "xb|c", true},
+	{"a(b|c)", "a(b|c)", true},
+	{"*.!(txt)", "file.tar.txt", true},
+	{"!(*.txt)", "x.txt.go", true},
+}
+
+func TestMatchExtGlob(t *testing.T) {
+	for _, test := range extTests {
+		p, err := CompileMode(test.pattern, ExtGlob)
+		if err != nil {
+			t.Errorf("Unexpected error for pattern %q: %v", test.pattern, err)
+			continue
+		}
+		if got := p.Match(test.s); got != test.match {
+			t.Errorf("Want %q matching %q == %v, got %v", test.pattern, test.s, test.match, got)
+		}
+	}
+}
+
+func TestMatchExtGlobDisabled(t *testing.T) {
+	var tests = []struct {
+		pattern string
+		s       string
+		match   bool
+	}{
+		{"@(a|b)", "@(a|b)", true},
+		{"@(a|b)", "a", false},
+		{"+([0-9])", "+(5)", true},
+		{"?(a|b)", "x(a|b)", true},
+		{"!(a)", "!(a)", true},
+	}
+	for _, test := range tests {
+		got, _ := Match(test.pattern, test.s)
+		if got != test.match {
+			t.Errorf("Want Match(%q, %q) == %v, got %v", test.pattern, test.s, test.match, got)
+		}
+	}
+}
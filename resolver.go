@@ -0,0 +1,162 @@
+package envsubst
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Resolver resolves the value of the named variable and reports
+// whether the variable is set. A Resolver can be passed as the lookup
+// function to EvalLookup, ExecuteLookup and ExecuteWith, and its Get
+// method as the mapping function to Eval and Execute.
+type Resolver func(name string) (string, bool)
+
+// Get returns the value of the named variable, or the empty string
+// if the variable is not set.
+func (r Resolver) Get(name string) string {
+	v, _ := r(name)
+	return v
+}
+
+// Chain returns a Resolver that resolves variables using each of the
+// resolvers in order. The first resolver that reports the variable is
+// set wins.
+func Chain(resolvers ...Resolver) Resolver {
+	return func(name string) (string, bool) {
+		for _, r := range resolvers {
+			if v, ok := r(name); ok {
+				return v, true
+			}
+		}
+		return "", false
+	}
+}
+
+// Env returns a Resolver that resolves variables from the process
+// environment.
+func Env() Resolver {
+	return os.LookupEnv
+}
+
+// Map returns a Resolver that resolves variables from the map.
+func Map(m map[string]string) Resolver {
+	return func(name string) (string, bool) {
+		v, ok := m[name]
+		return v, ok
+	}
+}
+
+// DotenvFiles returns a Resolver that resolves variables from the
+// named dotenv files. See LoadDotenv for details.
+func DotenvFiles(paths ...string) (Resolver, error) {
+	d, err := LoadDotenv(paths...)
+	if err != nil {
+		return nil, err
+	}
+	return d.Lookup, nil
+}
+
+// JSONFile returns a Resolver that resolves variables from the named
+// JSON file. The file must contain an object with scalar values, and
+// null values are treated as unset.
+func JSONFile(path string) (Resolver, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	m := map[string]string{}
+	for k, raw := range doc {
+		var v interface{}
+		d := json.NewDecoder(bytes.NewReader(raw))
+		d.UseNumber()
+		if err := d.Decode(&v); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		switch v := v.(type) {
+		case nil:
+		case string:
+			m[k] = v
+		case json.Number, bool:
+			m[k] = fmt.Sprint(v)
+		default:
+			return nil, fmt.Errorf("%s: unsupported value for %s", path, k)
+		}
+	}
+	return Map(m), nil
+}
+
+// Dir returns a Resolver that resolves variables from the files in
+// the named directory, where the file name is the variable name and
+// the file contents are the value, such as Docker and Kubernetes
+// secret mounts. Files are read when the variable is resolved.
+func Dir(dir string) Resolver {
+	return func(name string) (string, bool) {
+		if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+			return "", false
+		}
+		b, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return "", false
+		}
+		return string(b), true
+	}
+}
+
+// TrimPrefix returns a Resolver that resolves the named variable
+// from r with the prefix prepended to the name. This allows templates
+// to reference APP_HOST as ${HOST} using TrimPrefix("APP_", r).
+func TrimPrefix(prefix string, r Resolver) Resolver {
+	return func(name string) (string, bool) {
+		return r(prefix + name)
+	}
+}
+
+// IgnoreCase returns a Resolver that resolves variables from the map
+// without regard to the case of their names. An exact match is
+// preferred, and otherwise the first of the matching names in sorted
+// order is used.
+func IgnoreCase(m map[string]string) Resolver {
+	return func(name string) (string, bool) {
+		if v, ok := m[name]; ok {
+			return v, true
+		}
+		var key string
+		var found bool
+		for k := range m {
+			if strings.EqualFold(k, name) && (!found || k < key) {
+				key, found = k, true
+			}
+		}
+		if !found {
+			return "", false
+		}
+		return m[key], true
+	}
+}
+
+// EnvIgnoreCase returns a Resolver that resolves variables from the
+// process environment without regard to the case of their names. An
+// exact match is preferred, and otherwise the first matching variable
+// in the environment is used.
+func EnvIgnoreCase() Resolver {
+	return func(name string) (string, bool) {
+		if v, ok := os.LookupEnv(name); ok {
+			return v, true
+		}
+		for _, kv := range os.Environ() {
+			if i := strings.IndexByte(kv, '='); i > 0 && strings.EqualFold(kv[:i], name) {
+				return kv[i+1:], true
+			}
+		}
+		return "", false
+	}
+}
@@ -0,0 +1,121 @@
+package envsubst
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "envsubst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	secrets := filepath.Join(dir, "secrets")
+	os.Mkdir(secrets, 0755)
+	ioutil.WriteFile(filepath.Join(secrets, "DB_PASSWORD"), []byte("s3cret"), 0600)
+
+	jsonPath := filepath.Join(dir, "vars.json")
+	ioutil.WriteFile(jsonPath, []byte(`{"PORT": 8080, "DEBUG": true, "NAME": "web", "UNSET": null}`), 0644)
+
+	jsonFile, err := JSONFile(jsonPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := Chain(
+		Map(map[string]string{"EMPTY": ""}),
+		TrimPrefix("APP_", IgnoreCase(map[string]string{"APP_HOST": "example.com"})),
+		Dir(secrets),
+		jsonFile,
+	)
+
+	var tests = []struct {
+		name  string
+		value string
+		ok    bool
+	}{
+		{"EMPTY", "", true},
+		{"HOST", "example.com", true},
+		{"host", "example.com", true},
+		{"DB_PASSWORD", "s3cret", true},
+		{"../vars.json", "", false},
+		{"PORT", "8080", true},
+		{"DEBUG", "true", true},
+		{"NAME", "web", true},
+		{"UNSET", "", false},
+		{"MISSING", "", false},
+	}
+	for _, test := range tests {
+		v, ok := r(test.name)
+		if v != test.value || ok != test.ok {
+			t.Errorf("Want %s resolved to %q, %v, got %q, %v", test.name, test.value, test.ok, v, ok)
+		}
+	}
+
+	got, err := EvalLookup("${NAME}:${PORT} ${MISSING-default}", r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "web:8080 default"; got != want {
+		t.Errorf("Want %q, got %q", want, got)
+	}
+	if got, want := r.Get("MISSING"), ""; got != want {
+		t.Errorf("Want %q, got %q", want, got)
+	}
+}
+
+func TestIgnoreCase(t *testing.T) {
+	r := IgnoreCase(map[string]string{"DB_HOST": "db", "user": "admin", "Port": "8080", "PORT": "80"})
+	var tests = []struct {
+		name  string
+		value string
+		ok    bool
+	}{
+		{"db_host", "db", true},
+		{"DB_HOST", "db", true},
+		{"Db_Host", "db", true},
+		{"USER", "admin", true},
+		{"Port", "8080", true},
+		{"port", "80", true},
+		{"DB", "", false},
+	}
+	for _, test := range tests {
+		v, ok := r(test.name)
+		if v != test.value || ok != test.ok {
+			t.Errorf("Want %s resolved to %q, %v, got %q, %v", test.name, test.value, test.ok, v, ok)
+		}
+	}
+}
+
+func TestEnvIgnoreCase(t *testing.T) {
+	os.Setenv("ENVSUBST_TEST_HOST", "example.com")
+	defer os.Unsetenv("ENVSUBST_TEST_HOST")
+
+	r := EnvIgnoreCase()
+	for _, name := range []string{"ENVSUBST_TEST_HOST", "envsubst_test_host", "Envsubst_Test_Host"} {
+		if v, ok := r(name); !ok || v != "example.com" {
+			t.Errorf("Want %s resolved to %q, got %q, %v", name, "example.com", v, ok)
+		}
+	}
+	if v, ok := r("envsubst_test_port"); ok {
+		t.Errorf("Want envsubst_test_port unset, got %q", v)
+	}
+}
+
+func TestJSONFileError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "envsubst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "vars.json")
+	ioutil.WriteFile(path, []byte(`{"NESTED": {"A": 1}}`), 0644)
+	if _, err := JSONFile(path); err == nil {
+		t.Errorf("Want error for nested JSON values")
+	}
+}
@@ -0,0 +1,124 @@
+package structured
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/drone/envsubst/v2"
+)
+
+// JSON substitutes variables in the string values of the JSON
+// document, using the lookup function to resolve variable names. The
+// document is otherwise copied unchanged, preserving the order of
+// object keys, whitespace and number formatting.
+func JSON(b []byte, lookup func(string) (string, bool), opts Options) ([]byte, error) {
+	if !json.Valid(b) {
+		var v interface{}
+		return nil, json.Unmarshal(b, &v)
+	}
+	s := &substituter{lookup: lookup, opts: opts}
+	w := &jsonWriter{}
+	for i := 0; i < len(b); i++ {
+		c := b[i]
+		switch c {
+		case '"':
+			end := stringEnd(b, i)
+			if err := w.string(s, b[i:end]); err != nil {
+				return nil, err
+			}
+			i = end - 1
+			continue
+		case '{', '[':
+			w.stack = append(w.stack, jsonScope{
+				path:   w.path(),
+				object: c == '{',
+				key:    c == '{',
+			})
+		case '}', ']':
+			w.stack = w.stack[:len(w.stack)-1]
+		case ':':
+			w.top().key = false
+		case ',':
+			top := w.top()
+			top.key = top.object
+			top.index++
+		}
+		w.buf.WriteByte(c)
+	}
+	return w.buf.Bytes(), nil
+}
+
+// jsonScope is an object or array in the document.
+type jsonScope struct {
+	path   string // path of the object or array
+	object bool   // object, as opposed to array
+	key    bool   // the next string is an object key
+	name   string // current object key
+	index  int    // current array index
+}
+
+// jsonWriter writes the substituted document.
+type jsonWriter struct {
+	buf   bytes.Buffer
+	stack []jsonScope
+}
+
+// top returns the innermost object or array.
+func (w *jsonWriter) top() *jsonScope {
+	return &w.stack[len(w.stack)-1]
+}
+
+// path returns the path of the current value.
+func (w *jsonWriter) path() string {
+	if len(w.stack) == 0 {
+		return ""
+	}
+	top := w.top()
+	if top.object {
+		return appendKey(top.path, top.name)
+	}
+	return appendIndex(top.path, top.index)
+}
+
+// string writes the quoted string, substituting variables in values.
+func (w *jsonWriter) string(s *substituter, quoted []byte) error {
+	var v string
+	if err := json.Unmarshal(quoted, &v); err != nil {
+		return err
+	}
+	if len(w.stack) != 0 && w.top().key {
+		w.top().name = v
+		w.buf.Write(quoted)
+		return nil
+	}
+	if !bytes.ContainsRune(quoted, '$') {
+		w.buf.Write(quoted)
+		return nil
+	}
+	out, number, err := s.eval(w.path(), v)
+	if err != nil {
+		return err
+	}
+	if number {
+		w.buf.WriteString(out)
+		return nil
+	}
+	w.buf.WriteByte('"')
+	w.buf.WriteString(envsubst.JSONString(out))
+	w.buf.WriteByte('"')
+	return nil
+}
+
+// stringEnd returns the offset following the closing quote of the
+// string that starts at offset i of the valid JSON document.
+func stringEnd(b []byte, i int) int {
+	for i++; i < len(b); i++ {
+		switch b[i] {
+		case '\\':
+			i++
+		case '"':
+			return i + 1
+		}
+	}
+	return len(b)
+}
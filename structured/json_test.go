@@ -0,0 +1,83 @@
+package structured
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/drone/envsubst/v2/parse"
+)
+
+func TestJSON(t *testing.T) {
+	src := `{
+  "msg": "${MSG}",
+  "list": [1, "${IMAGE}", {"${KEY}": "${IMAGE}"}],
+  "port": "${PORT}",
+  "addr": ":${PORT}",
+  "debug": "${DEBUG}",
+  "empty": "${UNSET}",
+  "text": "a \"quoted\" $ value"
+}`
+	var tests = []struct {
+		opts Options
+		want string
+	}{
+		{
+			want: `{
+  "msg": "say \"hi\"\nbye",
+  "list": [1, "nginx", {"${KEY}": "nginx"}],
+  "port": "8080",
+  "addr": ":8080",
+  "debug": "true",
+  "empty": "",
+  "text": "a \"quoted\" $ value"
+}`,
+		},
+		{
+			opts: Options{Coerce: true},
+			want: `{
+  "msg": "say \"hi\"\nbye",
+  "list": [1, "nginx", {"${KEY}": "nginx"}],
+  "port": 8080,
+  "addr": ":8080",
+  "debug": "true",
+  "empty": "",
+  "text": "a \"quoted\" $ value"
+}`,
+		},
+	}
+	for _, test := range tests {
+		got, err := JSON([]byte(src), env, test.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != test.want {
+			t.Errorf("Want JSON with %+v\n%s\ngot\n%s", test.opts, test.want, got)
+		}
+	}
+
+	got, err := JSON([]byte(`"${PORT}"`), env, Options{Coerce: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "8080"; string(got) != want {
+		t.Errorf("Want JSON %s, got %s", want, got)
+	}
+}
+
+func TestJSONError(t *testing.T) {
+	_, err := JSON([]byte(`{"a": [{"b": "x"}, {"b": "${c"}]}`), env, Options{})
+	serr, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("Want structured Error, got %v", err)
+	}
+	if want := "a[1].b"; serr.Path != want {
+		t.Errorf("Want error path %q, got %q", want, serr.Path)
+	}
+	if !errors.Is(err, parse.ErrMissingClosingBrace) {
+		t.Errorf("Want missing closing brace error, got %v", err)
+	}
+
+	if _, err := JSON([]byte(`{"a": }`), env, Options{}); err == nil {
+		t.Errorf("Want JSON syntax error")
+	}
+}
@@ -0,0 +1,33 @@
+package structured
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/drone/envsubst/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// YAMLFile returns a Resolver that resolves variables from the named
+// YAML file. The file must contain a mapping with scalar values, and
+// null values are treated as unset.
+func YAMLFile(path string) (envsubst.Resolver, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc map[string]yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	m := map[string]string{}
+	for k, node := range doc {
+		switch {
+		case node.Kind != yaml.ScalarNode:
+			return nil, fmt.Errorf("%s: unsupported value for %s", path, k)
+		case node.ShortTag() != "!!null":
+			m[k] = node.Value
+		}
+	}
+	return envsubst.Map(m), nil
+}
@@ -0,0 +1,46 @@
+package structured
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestYAMLFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "envsubst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "vars.yaml")
+	ioutil.WriteFile(path, []byte("REPLICAS: 3\nNAME: api\nRATIO: 1.50\nUNSET: ~\n"), 0644)
+	r, err := YAMLFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name  string
+		value string
+		ok    bool
+	}{
+		{"REPLICAS", "3", true},
+		{"NAME", "api", true},
+		{"RATIO", "1.50", true},
+		{"UNSET", "", false},
+		{"MISSING", "", false},
+	}
+	for _, test := range tests {
+		v, ok := r(test.name)
+		if v != test.value || ok != test.ok {
+			t.Errorf("Want %s resolved to %q, %v, got %q, %v", test.name, test.value, test.ok, v, ok)
+		}
+	}
+
+	ioutil.WriteFile(path, []byte("NESTED:\n  A: 1\n"), 0644)
+	if _, err := YAMLFile(path); err == nil {
+		t.Errorf("Want error for nested YAML values")
+	}
+}
@@ -0,0 +1,107 @@
+// Package structured substitutes variables in the string values of
+// JSON and YAML documents.
+//
+// Unlike substitution of the raw document text, only string values
+// are substituted, and substituted values are encoded for the
+// document format, so that values containing quotes, colons or
+// newlines cannot change the structure of the document. Object keys
+// and mapping keys are not substituted.
+//
+// The package also provides YAMLFile, which resolves variables from a
+// YAML file, so that the envsubst package does not depend on a YAML
+// parser.
+package structured
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"github.com/drone/envsubst/v2"
+)
+
+// Options configures the substitution of a document.
+type Options struct {
+	// Parse configures the template parsed from each string value,
+	// such as envsubst.WithBareVariables().
+	Parse []envsubst.Option
+
+	// Execute configures the execution of each template.
+	Execute envsubst.ExecuteOptions
+
+	// Coerce causes a string value that consists of a single
+	// substitution, such as "${PORT}", to be written as a number
+	// when the substituted value is a number.
+	Coerce bool
+}
+
+// Error is returned when a string value cannot be substituted.
+type Error struct {
+	Path string // path of the value, such as spec.containers[0].image
+	Err  error  // underlying error
+}
+
+func (e *Error) Error() string {
+	if e.Path == "" {
+		return e.Err.Error()
+	}
+	return e.Path + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// substituter substitutes variables in string values.
+type substituter struct {
+	lookup func(string) (string, bool)
+	opts   Options
+}
+
+// eval substitutes variables in the string value at the path. It
+// reports whether the result is a number that replaces a single
+// substitution, when the Coerce option is enabled.
+func (s *substituter) eval(path, v string) (string, bool, error) {
+	if !strings.Contains(v, "$") {
+		return v, false, nil
+	}
+	t, err := envsubst.Parse(v, s.opts.Parse...)
+	if err != nil {
+		return "", false, &Error{Path: path, Err: err}
+	}
+	out, err := t.ExecuteWith(s.lookup, s.opts.Execute)
+	if err != nil {
+		return "", false, &Error{Path: path, Err: err}
+	}
+	return out, s.opts.Coerce && t.IsSubstitution() && isNumber(out), nil
+}
+
+// isNumber reports whether the string is a JSON number.
+func isNumber(s string) bool {
+	if s == "" || s != strings.TrimSpace(s) {
+		return false
+	}
+	if c := s[0]; c != '-' && (c < '0' || c > '9') {
+		return false
+	}
+	return json.Valid([]byte(s))
+}
+
+// isInteger reports whether the JSON number is an integer.
+func isInteger(s string) bool {
+	return !strings.ContainsAny(s, ".eE")
+}
+
+// appendKey returns the path of the object key.
+func appendKey(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// appendIndex returns the path of the array index.
+func appendIndex(path string, i int) string {
+	return path + "[" + strconv.Itoa(i) + "]"
+}
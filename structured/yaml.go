@@ -0,0 +1,90 @@
+package structured
+
+import (
+	"bytes"
+	"io"
+
+	"gopkg.in/yaml.v3"
+)
+
+// YAML substitutes variables in the string values of the YAML
+// document, using the lookup function to resolve variable names. The
+// document may contain multiple documents separated by "---". Key
+// order, comments and the quoting style of values are preserved, and
+// the document is written with an indentation of two spaces.
+func YAML(b []byte, lookup func(string) (string, bool), opts Options) ([]byte, error) {
+	var buf bytes.Buffer
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	for {
+		var doc yaml.Node
+		err := dec.Decode(&doc)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := Node(&doc, lookup, opts); err != nil {
+			return nil, err
+		}
+		if err := enc.Encode(&doc); err != nil {
+			return nil, err
+		}
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Node substitutes variables in the string scalars of the decoded
+// YAML node and its children, using the lookup function to resolve
+// variable names. Mapping keys and aliases are not substituted.
+func Node(node *yaml.Node, lookup func(string) (string, bool), opts Options) error {
+	s := &substituter{lookup: lookup, opts: opts}
+	return s.node(node, "")
+}
+
+func (s *substituter) node(node *yaml.Node, path string) error {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, n := range node.Content {
+			if err := s.node(n, path); err != nil {
+				return err
+			}
+		}
+	case yaml.SequenceNode:
+		for i, n := range node.Content {
+			if err := s.node(n, appendIndex(path, i)); err != nil {
+				return err
+			}
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if err := s.node(value, appendKey(path, key.Value)); err != nil {
+				return err
+			}
+		}
+	case yaml.ScalarNode:
+		if node.ShortTag() != "!!str" {
+			return nil
+		}
+		out, number, err := s.eval(path, node.Value)
+		if err != nil {
+			return err
+		}
+		node.Value = out
+		// an explicit tag, such as !!str ${PORT}, is not coerced.
+		if number && node.Style&yaml.TaggedStyle == 0 {
+			node.Tag = "!!float"
+			if isInteger(out) {
+				node.Tag = "!!int"
+			}
+			node.Style = 0
+		}
+	}
+	return nil
+}
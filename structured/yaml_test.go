@@ -0,0 +1,133 @@
+package structured
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/drone/envsubst/v2"
+	"gopkg.in/yaml.v3"
+)
+
+var env = envsubst.Map(map[string]string{
+	"NAME":  "web: prod",
+	"IMAGE": "nginx",
+	"PORT":  "8080",
+	"DEBUG": "true",
+	"MSG":   "say \"hi\"\nbye",
+})
+
+func TestYAML(t *testing.T) {
+	src := `# config
+name: ${NAME} # the name
+image: "${IMAGE}"
+port: ${PORT}
+debug: ${DEBUG}
+keep: !!str ${PORT}
+msg: ${MSG}
+count: 3
+${KEY}: value
+list:
+    - ${NAME}
+    - 'a: ${NAME}'
+---
+other: "${PORT}"
+`
+	var tests = []struct {
+		opts Options
+		want string
+	}{
+		{
+			want: `# config
+name: 'web: prod' # the name
+image: "nginx"
+port: "8080"
+debug: "true"
+keep: !!str 8080
+msg: |-
+  say "hi"
+  bye
+count: 3
+${KEY}: value
+list:
+  - 'web: prod'
+  - 'a: web: prod'
+---
+other: "8080"
+`,
+		},
+		{
+			opts: Options{Coerce: true},
+			want: `# config
+name: 'web: prod' # the name
+image: "nginx"
+port: 8080
+debug: "true"
+keep: !!str 8080
+msg: |-
+  say "hi"
+  bye
+count: 3
+${KEY}: value
+list:
+  - 'web: prod'
+  - 'a: web: prod'
+---
+other: 8080
+`,
+		},
+	}
+	for _, test := range tests {
+		got, err := YAML([]byte(src), env, test.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != test.want {
+			t.Errorf("Want YAML with %+v\n%s\ngot\n%s", test.opts, test.want, got)
+		}
+	}
+}
+
+func TestYAMLError(t *testing.T) {
+	src := "spec:\n  containers:\n    - image: ${IMAGE}\n    - image: ${TAG:?required}\n"
+	_, err := YAML([]byte(src), env, Options{})
+	serr, ok := err.(*Error)
+	if !ok {
+		t.Fatalf("Want structured Error, got %v", err)
+	}
+	if want := "spec.containers[1].image"; serr.Path != want {
+		t.Errorf("Want error path %q, got %q", want, serr.Path)
+	}
+	var rerr *envsubst.RequiredVariableError
+	if !errors.As(err, &rerr) {
+		t.Errorf("Want required variable error, got %v", err)
+	}
+	if want := "spec.containers[1].image: TAG: required"; err.Error() != want {
+		t.Errorf("Want error %q, got %q", want, err)
+	}
+
+	if _, err := YAML([]byte("a: [b"), env, Options{}); err == nil {
+		t.Errorf("Want YAML syntax error")
+	}
+}
+
+// TestYAMLScalar checks that values escaped with envsubst.YAMLScalar
+// are read back as the same string.
+func TestYAMLScalar(t *testing.T) {
+	values := []string{
+		"nginx", "true", "True", "no", "off", "null", "~", "", "007",
+		"0x2a", "0o17", "1_000", "1e3", "-1", "+1", ".5", "-.5", ".inf",
+		"-.Inf", ".NaN", "2001-12-14", "2001-12-14T21:59:43.10-05:00",
+		"1.2.3", "v1.2", "a: b", "- a", "<<", "it's", "a\nb", "é",
+	}
+	for _, v := range values {
+		var doc map[string]interface{}
+		src := "v: " + envsubst.YAMLScalar(v)
+		if err := yaml.Unmarshal([]byte(src), &doc); err != nil {
+			t.Errorf("Want %q parsed without error, got %v", src, err)
+			continue
+		}
+		if got, ok := doc["v"].(string); !ok || got != v {
+			t.Errorf("Want %q read as string %q, got %#v", src, v, doc["v"])
+		}
+	}
+}
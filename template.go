@@ -1,11 +1,14 @@
 package envsubst
 
 import (
-	"bytes"
+	"bufio"
 	"io"
 	"io/ioutil"
+	"strconv"
+	"strings"
 
 	"github.com/drone/envsubst/v2/parse"
+	"github.com/drone/envsubst/v2/pattern"
 )
 
 // state represents the state of template execution. It is not part of the
@@ -15,62 +18,554 @@ type state struct {
 	writer   io.Writer
 	node     parse.Node // current node
 
-	// maps variable names to values
-	mapper func(string) string
+	// maps variable names to values and reports whether
+	// the variable is set.
+	lookup func(string) (string, bool)
+
+	// values assigned during execution by the ${var=word}
+	// and ${var:=word} functions.
+	assigned map[string]string
+
+	// names of unset variables referenced during execution,
+	// recorded when the NoUnset option is enabled.
+	noUnset bool
+	unset   []string
+
+	// reports whether references to the named variable
+	// are written to the output unchanged.
+	preserve func(string) bool
+
+	// reports whether a substitution is being evaluated for
+	// the escaper, so that nested substitutions are escaped once.
+	escaping bool
+}
+
+// ExecuteOptions configures the execution of a template.
+type ExecuteOptions struct {
+	// NoUnset causes execution to fail when the template references
+	// a variable that is not set, similar to set -u. References that
+	// provide a default or alternate value, such as ${var:-word}, are
+	// permitted.
+	NoUnset bool
+
+	// Preserve reports whether references to the named variable
+	// are written to the output unchanged instead of substituted.
+	Preserve func(name string) bool
+}
+
+// RequiredVariableError is returned when a variable referenced by the
+// ${var?message} or ${var:?message} functions is null.
+type RequiredVariableError struct {
+	Name    string // variable name
+	Message string // evaluated message
+	Pos     int    // byte offset of the function in the template
+}
+
+func (e *RequiredVariableError) Error() string {
+	if e.Message == "" {
+		return e.Name + ": parameter null or not set"
+	}
+	return e.Name + ": " + e.Message
+}
+
+// UnsetVariableError is returned when a template executed with the
+// NoUnset option references variables that are not set.
+type UnsetVariableError struct {
+	Names []string // variable names, in order of reference
+}
+
+func (e *UnsetVariableError) Error() string {
+	if len(e.Names) == 1 {
+		return e.Names[0] + ": unbound variable"
+	}
+	return "unbound variables: " + strings.Join(e.Names, ", ")
+}
+
+// ArithmeticError is returned when an arithmetic expression, such as
+// the contents of $((expr)) or the offset of ${var:offset}, cannot be
+// evaluated.
+type ArithmeticError struct {
+	Expr string // expression, after substitution
+	Err  error  // underlying error, such as parse.ErrDivisionByZero
+	Pos  int    // byte offset of the function in the template
+}
+
+func (e *ArithmeticError) Error() string {
+	return e.Expr + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *ArithmeticError) Unwrap() error {
+	return e.Err
 }
 
 // Template is the representation of a parsed shell format string.
 type Template struct {
-	tree *parse.Tree
+	tree     *parse.Tree
+	text     string
+	mode     parse.Mode
+	patterns pattern.Mode
+	bytes    bool
+	funcs    map[string]Func
+	escaper  Escaper
+
+	// function arguments without substitutions, compiled at
+	// parse time.
+	static *static
+}
+
+// Func is a function that transforms a variable value, referenced
+// by name in the ${var|func} pipe function.
+type Func func(string) string
+
+// Option configures a Template.
+type Option func(*Template)
+
+// WithBareVariables enables unbraced variable references such as
+// $var and $1 in addition to ${var}. By default unbraced references
+// are treated as literal text.
+func WithBareVariables() Option {
+	return func(t *Template) {
+		t.mode |= parse.ParseBare
+	}
+}
+
+// WithExtGlob enables the extended pattern matching operators
+// ?(list), *(list), +(list), @(list) and !(list) in the patterns of
+// the trim and replace functions, similar to shopt -s extglob. By
+// default these operators are treated as literal text.
+func WithExtGlob() Option {
+	return func(t *Template) {
+		t.patterns |= pattern.ExtGlob
+	}
+}
+
+// WithByteMode causes the ${#var} and ${var:offset:length} functions
+// to count bytes instead of characters. By default they count
+// characters, similar to bash in a UTF-8 locale.
+func WithByteMode() Option {
+	return func(t *Template) {
+		t.bytes = true
+	}
+}
+
+// WithArithmetic enables arithmetic expansions such as $((x+1)). By
+// default arithmetic expansions are treated as literal text. Offsets
+// of the ${var:offset:length} function are always evaluated as
+// arithmetic expressions.
+func WithArithmetic() Option {
+	return func(t *Template) {
+		t.mode |= parse.ParseArithmetic
+	}
+}
+
+// WithLiteralDollars disables the $$ escape, so that $$ is literal
+// text, like GNU envsubst. By default $$ is replaced with a single
+// dollar sign.
+func WithLiteralDollars() Option {
+	return func(t *Template) {
+		t.mode |= parse.ParseLiteralDollars
+	}
+}
+
+// Funcs adds the functions to the functions that may be referenced
+// by the ${var|func} pipe function, such as ${var|base64}. Functions
+// are applied in order, so ${var|trim|base64} encodes the trimmed
+// value. Templates that reference an undefined function fail to
+// parse.
+func Funcs(funcs map[string]Func) Option {
+	return func(t *Template) {
+		if t.funcs == nil {
+			t.funcs = map[string]Func{}
+		}
+		for name, fn := range funcs {
+			t.funcs[name] = fn
+		}
+	}
+}
+
+// WithEscaper escapes the value of each substitution for the output
+// format of the template, such as WithEscaper(JSONString). Literal
+// template text is not escaped.
+func WithEscaper(e Escaper) Option {
+	return func(t *Template) {
+		t.escaper = e
+	}
 }
 
 // Parse creates a new shell format template and parses the template
 // definition from string s.
-func Parse(s string) (t *Template, err error) {
-	t = new(Template)
-	t.tree, err = parse.Parse(s)
-	if err != nil {
+func Parse(s string, opts ...Option) (*Template, error) {
+	t := newTemplate(opts)
+	if err := t.parse(s); err != nil {
 		return nil, err
 	}
 	return t, nil
 }
 
+// newTemplate returns a new template configured with the options.
+func newTemplate(opts []Option) *Template {
+	t := new(Template)
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+// parse parses the template definition from string s, and compiles
+// the function arguments that contain no substitutions.
+func (t *Template) parse(s string) (err error) {
+	var names map[string]bool
+	if len(t.funcs) != 0 {
+		names = map[string]bool{}
+	}
+	for name := range t.funcs {
+		names[name] = true
+	}
+	t.text = s
+	t.tree, err = parse.ParseFuncs(s, t.mode, names)
+	if err != nil {
+		return err
+	}
+	t.static = compileStatic(t.tree, t.patterns)
+	return nil
+}
+
 // ParseFile creates a new shell format template and parses the template
-// definition from the named file.
-func ParseFile(path string) (*Template, error) {
+// definition from the named file. Parse errors include the file name.
+func ParseFile(path string, opts ...Option) (*Template, error) {
 	b, err := ioutil.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
-	return Parse(string(b))
+	t, err := Parse(string(b), opts...)
+	if perr, ok := err.(*parse.Error); ok {
+		perr.Filename = path
+	}
+	return t, err
 }
 
 // Execute applies a parsed template to the specified data mapping.
+// The mapping function cannot distinguish between unset and empty
+// variables, so an empty value is treated as unset.
 func (t *Template) Execute(mapping func(string) string) (str string, err error) {
-	b := new(bytes.Buffer)
+	return t.ExecuteLookup(toLookup(mapping))
+}
+
+// ExecuteLookup applies a parsed template to the specified lookup
+// function. The lookup function reports whether the variable is set,
+// in the same manner as os.LookupEnv.
+func (t *Template) ExecuteLookup(lookup func(string) (string, bool)) (str string, err error) {
+	return t.ExecuteWith(lookup, ExecuteOptions{})
+}
+
+// ExecuteWith applies a parsed template to the specified lookup
+// function using the specified execution options.
+func (t *Template) ExecuteWith(lookup func(string) (string, bool), opts ExecuteOptions) (str string, err error) {
+	b := new(strings.Builder)
+	b.Grow(len(t.text))
+	err = t.execute(b, lookup, opts)
+	if err != nil {
+		return
+	}
+	return b.String(), nil
+}
+
+// ExecuteTo applies a parsed template to the specified data mapping,
+// and writes the output to w. If an error occurs executing the
+// template, partial output may have been written to w.
+func (t *Template) ExecuteTo(w io.Writer, mapping func(string) string) error {
+	return t.ExecuteToWith(w, toLookup(mapping), ExecuteOptions{})
+}
+
+// ExecuteToWith applies a parsed template to the specified lookup
+// function using the specified execution options, and writes the
+// output to w. If an error occurs executing the template, partial
+// output may have been written to w.
+func (t *Template) ExecuteToWith(w io.Writer, lookup func(string) (string, bool), opts ExecuteOptions) error {
+	bw := bufio.NewWriter(w)
+	err := t.execute(bw, lookup, opts)
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func (t *Template) execute(w io.Writer, lookup func(string) (string, bool), opts ExecuteOptions) error {
 	s := new(state)
 	s.node = t.tree.Root
-	s.mapper = mapping
-	s.writer = b
-	err = t.eval(s)
+	s.lookup = lookup
+	s.writer = w
+	s.noUnset = opts.NoUnset
+	s.preserve = opts.Preserve
+	err := t.eval(s)
 	if err != nil {
+		return err
+	}
+	if len(s.unset) != 0 {
+		return &UnsetVariableError{Names: s.unset}
+	}
+	return nil
+}
+
+// toLookup returns a lookup function for the data mapping that
+// treats an empty value as unset.
+func toLookup(mapping func(string) string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v := mapping(name)
+		return v, v != ""
+	}
+}
+
+// Tree returns a copy of the parse tree of the template. Changes to
+// the returned tree do not affect the template.
+func (t *Template) Tree() *parse.Tree {
+	return t.tree.Copy()
+}
+
+// IsSubstitution reports whether the template consists of a single
+// substitution, such as ${PORT}, without surrounding text.
+func (t *Template) IsSubstitution() bool {
+	switch t.tree.Root.(type) {
+	case nil, *parse.TextNode, *parse.ListNode:
+		return false
+	}
+	return true
+}
+
+// Variable describes a variable referenced by a template.
+type Variable struct {
+	Name      string   // variable name
+	Default   bool     // referenced with a default value, such as ${var:-word}
+	Required  bool     // referenced with ${var?word} or ${var:?word}
+	Operators []string // operators applied to the variable, such as ":-", "^^" or "|base64"
+}
+
+// Variables returns the variables referenced by the template in order
+// of first reference, including variables nested in function arguments
+// and variables named in arithmetic expressions.
+func (t *Template) Variables() []Variable {
+	var vars []Variable
+	index := map[string]int{}
+	add := func(name string) *Variable {
+		i, ok := index[name]
+		if !ok {
+			i = len(vars)
+			index[name] = i
+			vars = append(vars, Variable{Name: name})
+		}
+		return &vars[i]
+	}
+	parse.Inspect(t.tree.Root, func(node parse.Node) bool {
+		sub, ok := describe(node)
+		switch n := node.(type) {
+		case *parse.SubstrNode:
+			add(n.Name)
+			arithNames(n.Offset, add)
+			arithNames(n.Length, add)
+		case *parse.ArithNode:
+			arithNames(n.Expr, add)
+		}
+		if !ok {
+			return true
+		}
+		v := add(sub.name)
+		switch sub.op {
+		case "":
+			return true
+		case "-", "=", ":-", ":=":
+			v.Default = true
+		case "?", ":?":
+			v.Required = true
+		}
+		for _, op := range v.Operators {
+			if op == sub.op {
+				return true
+			}
+		}
+		v.Operators = append(v.Operators, sub.op)
+		return true
+	})
+	return vars
+}
+
+// arithNames calls fn with each variable named in the arithmetic
+// expression of the node. Substitutions in the expression are
+// reported separately, and are replaced with zero to parse the
+// expression.
+func arithNames(node parse.Node, fn func(string) *Variable) {
+	var expr string
+	switch n := node.(type) {
+	case nil:
+		return
+	case *parse.TextNode:
+		expr = n.Value
+	case *parse.ListNode:
+		var b strings.Builder
+		for _, c := range n.Nodes {
+			if text, ok := c.(*parse.TextNode); ok {
+				b.WriteString(text.Value)
+			} else {
+				b.WriteString("0")
+			}
+		}
+		expr = b.String()
+	default:
 		return
 	}
-	return b.String(), nil
+	x, err := parse.ParseArith(expr)
+	if err != nil {
+		return
+	}
+	walkArith(x, fn)
+}
+
+// walkArith calls fn with each variable named in the expression, in
+// the order of the expression text.
+func walkArith(x parse.Expr, fn func(string) *Variable) {
+	switch x := x.(type) {
+	case *parse.NameExpr:
+		fn(x.Name)
+	case *parse.UnaryExpr:
+		walkArith(x.X, fn)
+	case *parse.BinaryExpr:
+		walkArith(x.X, fn)
+		walkArith(x.Y, fn)
+	case *parse.ParenExpr:
+		walkArith(x.X, fn)
+	}
+}
+
+// substitution describes a parameter substitution node.
+type substitution struct {
+	name string         // variable name
+	op   string         // operator, such as ":-" or "^^"
+	pos  parse.Position // source position
+}
+
+// describe returns the description of a parameter substitution node,
+// and reports whether the node is a parameter substitution.
+func describe(node parse.Node) (substitution, bool) {
+	switch n := node.(type) {
+	case *parse.ParamNode:
+		return substitution{n.Name, "", n.Position}, true
+	case *parse.LenNode:
+		return substitution{n.Name, "#", n.Position}, true
+	case *parse.CaseNode:
+		op := ","
+		if n.Upper {
+			op = "^"
+		}
+		if !n.First {
+			op += op
+		}
+		return substitution{n.Name, op, n.Position}, true
+	case *parse.SubstrNode:
+		return substitution{n.Name, ":", n.Position}, true
+	case *parse.TrimNode:
+		op := "#"
+		if n.Suffix {
+			op = "%"
+		}
+		if n.Longest {
+			op += op
+		}
+		return substitution{n.Name, op, n.Position}, true
+	case *parse.ReplaceNode:
+		op := "/"
+		switch {
+		case n.All:
+			op = "//"
+		case n.Prefix:
+			op = "/#"
+		case n.Suffix:
+			op = "/%"
+		}
+		return substitution{n.Name, op, n.Position}, true
+	case *parse.DefaultNode:
+		op := "-"
+		if n.Assign {
+			op = "="
+		}
+		return substitution{n.Name, colon(n.Colon) + op, n.Position}, true
+	case *parse.RequiredNode:
+		return substitution{n.Name, colon(n.Colon) + "?", n.Position}, true
+	case *parse.AlternateNode:
+		return substitution{n.Name, colon(n.Colon) + "+", n.Position}, true
+	case *parse.PipeNode:
+		return substitution{n.Name, "|" + strings.Join(n.Funcs, "|"), n.Position}, true
+	}
+	return substitution{}, false
+}
+
+// colon returns the colon prefix of an operator.
+func colon(ok bool) string {
+	if ok {
+		return ":"
+	}
+	return ""
 }
 
 func (t *Template) eval(s *state) (err error) {
+	if s.preserve != nil {
+		if sub, ok := describe(s.node); ok && s.preserve(sub.name) {
+			_, err = io.WriteString(s.writer, t.text[sub.pos.Pos:sub.pos.End])
+			return err
+		}
+	}
+
+	if t.escaper != nil && !s.escaping {
+		switch s.node.(type) {
+		case *parse.TextNode, *parse.ListNode:
+		default:
+			return t.evalEscaped(s)
+		}
+	}
+
 	switch node := s.node.(type) {
 	case *parse.TextNode:
 		err = t.evalText(s, node)
-	case *parse.FuncNode:
-		err = t.evalFunc(s, node)
 	case *parse.ListNode:
 		err = t.evalList(s, node)
+	case *parse.ParamNode:
+		err = t.evalParam(s, node)
+	case *parse.LenNode:
+		err = t.evalLen(s, node)
+	case *parse.CaseNode:
+		err = t.evalCase(s, node)
+	case *parse.SubstrNode:
+		err = t.evalSubstr(s, node)
+	case *parse.TrimNode:
+		err = t.evalTrim(s, node)
+	case *parse.ReplaceNode:
+		err = t.evalReplace(s, node)
+	case *parse.DefaultNode:
+		err = t.evalDefault(s, node)
+	case *parse.RequiredNode:
+		err = t.evalRequired(s, node)
+	case *parse.AlternateNode:
+		err = t.evalAlternate(s, node)
+	case *parse.PipeNode:
+		err = t.evalPipe(s, node)
+	case *parse.ArithNode:
+		err = t.evalArith(s, node)
 	}
 	return err
 }
 
+// evalEscaped evaluates the substitution and writes the value
+// escaped by the template escaper.
+func (t *Template) evalEscaped(s *state) error {
+	s.escaping = true
+	v, err := t.evalString(s, s.node)
+	s.escaping = false
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(s.writer, t.escaper(v))
+	return err
+}
+
 func (t *Template) evalText(s *state, node *parse.TextNode) error {
 	_, err := io.WriteString(s.writer, node.Value)
 	return err
@@ -87,71 +582,309 @@ func (t *Template) evalList(s *state, node *parse.ListNode) (err error) {
 	return nil
 }
 
-func (t *Template) evalFunc(s *state, node *parse.FuncNode) error {
-	var w = s.writer
-	var buf bytes.Buffer
-	var args []string
-	for _, n := range node.Args {
-		buf.Reset()
-		s.writer = &buf
-		s.node = n
-		err := t.eval(s)
+// evalParam evaluates the ${var} parameter.
+func (t *Template) evalParam(s *state, node *parse.ParamNode) error {
+	v := s.lookupParam(node.Name)
+	_, err := io.WriteString(s.writer, v)
+	return err
+}
+
+// evalLen evaluates the ${#var} function.
+func (t *Template) evalLen(s *state, node *parse.LenNode) error {
+	v := s.lookupParam(node.Name)
+	fn := toLen
+	if t.bytes {
+		fn = toByteLen
+	}
+	_, err := io.WriteString(s.writer, fn(v))
+	return err
+}
+
+// evalCase evaluates the ${var^} family of functions.
+func (t *Template) evalCase(s *state, node *parse.CaseNode) error {
+	v := s.lookupParam(node.Name)
+	var fn substituteFunc
+	switch {
+	case node.Upper && node.First:
+		fn = toUpperFirst
+	case node.Upper:
+		fn = toUpper
+	case node.First:
+		fn = toLowerFirst
+	default:
+		fn = toLower
+	}
+	_, err := io.WriteString(s.writer, fn(v))
+	return err
+}
+
+// evalSubstr evaluates the ${var:offset:length} function. The
+// offset and length are evaluated as arithmetic expressions.
+func (t *Template) evalSubstr(s *state, node *parse.SubstrNode) error {
+	v := s.lookupParam(node.Name)
+	nums := make([]string, 0, 2)
+	for _, arg := range []parse.Node{node.Offset, node.Length} {
+		if arg == nil {
+			continue
+		}
+		n, err := t.evalArithArg(s, arg, node.Pos)
 		if err != nil {
 			return err
 		}
-		args = append(args, buf.String())
+		nums = append(nums, strconv.FormatInt(n, 10))
+	}
+
+	fn := toSubstr
+	if t.bytes {
+		fn = toByteSubstr
+	}
+	_, err := io.WriteString(s.writer, fn(v, nums...))
+	return err
+}
+
+// evalTrim evaluates the ${var#pattern} family of functions.
+func (t *Template) evalTrim(s *state, node *parse.TrimNode) error {
+	v := s.lookupParam(node.Name)
+	pat, err := t.evalString(s, node.Pattern)
+	if err != nil {
+		return err
+	}
+
+	m := matcher{mode: t.patterns, static: t.static.pattern(node.Pattern)}
+	var fn substituteFunc
+	switch {
+	case node.Suffix && node.Longest:
+		fn = m.trimLongestSuffix
+	case node.Suffix:
+		fn = m.trimShortestSuffix
+	case node.Longest:
+		fn = m.trimLongestPrefix
+	default:
+		fn = m.trimShortestPrefix
+	}
+	_, err = io.WriteString(s.writer, fn(v, pat))
+	return err
+}
+
+// evalReplace evaluates the ${var/pattern/replacement} family of
+// functions.
+func (t *Template) evalReplace(s *state, node *parse.ReplaceNode) error {
+	v, ok := s.lookupVar(node.Name)
+	if !ok {
+		// like bash, an unset variable expands to the empty string,
+		// although the pattern may match the empty string.
+		if s.noUnset {
+			s.addUnset(node.Name)
+		}
+		return nil
+	}
+	pat, err := t.evalString(s, node.Pattern)
+	if err != nil {
+		return err
+	}
+	repl, err := t.evalString(s, node.Replacement)
+	if err != nil {
+		return err
+	}
+
+	m := matcher{mode: t.patterns, static: t.static.pattern(node.Pattern)}
+	var fn substituteFunc
+	switch {
+	case node.All:
+		fn = m.replaceAll
+	case node.Prefix:
+		fn = m.replacePrefix
+	case node.Suffix:
+		fn = m.replaceSuffix
+	default:
+		fn = m.replaceFirst
+	}
+	_, err = io.WriteString(s.writer, fn(v, pat, repl))
+	return err
+}
+
+// evalDefault evaluates the ${var-word} family of functions. The
+// word is only evaluated when the variable is unset, or when the
+// variable is empty and the function includes a colon. The
+// ${var=word} functions also assign the word to the variable.
+func (t *Template) evalDefault(s *state, node *parse.DefaultNode) error {
+	v, ok := s.lookupVar(node.Name)
+	if !isNull(node.Colon, v, ok) {
+		_, err := io.WriteString(s.writer, v)
+		return err
+	}
+
+	v, err := t.evalString(s, node.Value)
+	if err != nil {
+		return err
+	}
+	if node.Assign {
+		if s.assigned == nil {
+			s.assigned = map[string]string{}
+		}
+		s.assigned[node.Name] = v
+	}
+
+	_, err = io.WriteString(s.writer, v)
+	return err
+}
+
+// evalRequired evaluates the ${var?message} family of functions. The
+// message is only evaluated when the variable is unset, or when the
+// variable is empty and the function includes a colon, in which case
+// a RequiredVariableError is returned.
+func (t *Template) evalRequired(s *state, node *parse.RequiredNode) error {
+	v, ok := s.lookupVar(node.Name)
+	if !isNull(node.Colon, v, ok) {
+		_, err := io.WriteString(s.writer, v)
+		return err
+	}
+
+	msg, err := t.evalString(s, node.Message)
+	if err != nil {
+		return err
+	}
+	return &RequiredVariableError{
+		Name:    node.Name,
+		Message: msg,
+		Pos:     node.Pos,
 	}
+}
+
+// evalAlternate evaluates the ${var+word} family of functions. The
+// word is only evaluated when the variable is set, and when the
+// variable is not empty if the function includes a colon.
+func (t *Template) evalAlternate(s *state, node *parse.AlternateNode) error {
+	v, ok := s.lookupVar(node.Name)
+	if isNull(node.Colon, v, ok) {
+		return nil
+	}
+
+	v, err := t.evalString(s, node.Value)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(s.writer, v)
+	return err
+}
+
+// evalPipe evaluates the ${var|func} pipe function.
+func (t *Template) evalPipe(s *state, node *parse.PipeNode) error {
+	v := s.lookupParam(node.Name)
+	for _, name := range node.Funcs {
+		v = t.funcs[name](v)
+	}
+	_, err := io.WriteString(s.writer, v)
+	return err
+}
+
+// evalArith evaluates the $((expr)) arithmetic expansion. The
+// expression is evaluated after substitution, similar to bash.
+func (t *Template) evalArith(s *state, node *parse.ArithNode) error {
+	expr, err := t.evalString(s, node.Expr)
+	if err != nil {
+		return err
+	}
+	n, err := s.arith(expr, node.Pos)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(s.writer, strconv.FormatInt(n, 10))
+	return err
+}
+
+// evalString evaluates the node and returns the resulting string.
+func (t *Template) evalString(s *state, node parse.Node) (string, error) {
+	if text, ok := node.(*parse.TextNode); ok {
+		return text.Value, nil
+	}
+	var w, n = s.writer, s.node
+	var buf strings.Builder
+	s.writer = &buf
+	s.node = node
+	err := t.eval(s)
 
 	// restore the origin writer
 	s.writer = w
-	s.node = node
+	s.node = n
+	return buf.String(), err
+}
 
-	v := s.mapper(node.Param)
+// evalArithArg evaluates the function argument as an arithmetic
+// expression. Arguments without substitutions are parsed once, when
+// the template is parsed.
+func (t *Template) evalArithArg(s *state, node parse.Node, pos int) (int64, error) {
+	if x := t.static.expr(node); x != nil {
+		return s.evalArith(x, node.(*parse.TextNode).Value, pos)
+	}
+	expr, err := t.evalString(s, node)
+	if err != nil {
+		return 0, err
+	}
+	return s.arith(expr, pos)
+}
 
-	fn := lookupFunc(node.Name, len(args))
+// arith evaluates the arithmetic expression of the function at the
+// byte offset pos. Variable names in the expression are resolved in
+// the same manner as substitutions.
+func (s *state) arith(expr string, pos int) (int64, error) {
+	x, err := parse.ParseArith(expr)
+	if perr, ok := err.(*parse.Error); ok {
+		return 0, &ArithmeticError{Expr: strings.TrimSpace(expr), Err: perr.Err, Pos: pos}
+	}
+	return s.evalArith(x, expr, pos)
+}
 
-	_, err := io.WriteString(s.writer, fn(v, args...))
-	return err
+// evalArith evaluates the parsed arithmetic expression expr of the
+// function at the byte offset pos.
+func (s *state) evalArith(x parse.Expr, expr string, pos int) (int64, error) {
+	n, err := parse.EvalArith(x, func(name string) (string, bool) {
+		v := s.lookupParam(name)
+		return v, v != ""
+	})
+	if err != nil {
+		return 0, &ArithmeticError{Expr: strings.TrimSpace(expr), Err: err, Pos: pos}
+	}
+	return n, nil
 }
 
-// lookupFunc returns the parameters substitution function by name. If the
-// named function does not exists, a default function is returned.
-func lookupFunc(name string, args int) substituteFunc {
-	switch name {
-	case ",":
-		return toLowerFirst
-	case ",,":
-		return toLower
-	case "^":
-		return toUpperFirst
-	case "^^":
-		return toUpper
-	case "#":
-		if args == 0 {
-			return toLen
-		}
-		return trimShortestPrefix
-	case "##":
-		return trimLongestPrefix
-	case "%":
-		return trimShortestSuffix
-	case "%%":
-		return trimLongestSuffix
-	case ":":
-		return toSubstr
-	case "/#":
-		return replacePrefix
-	case "/%":
-		return replaceSuffix
-	case "/":
-		return replaceFirst
-	case "//":
-		return replaceAll
-	case "=", ":=", ":-":
-		return toDefault
-	case ":?", ":+", "-", "+":
-		return toDefault
-	default:
-		return toDefault
+// lookupVar returns the value of the named variable and reports
+// whether the variable is set. Values assigned during execution
+// take precedence over the lookup function.
+func (s *state) lookupVar(name string) (string, bool) {
+	if v, ok := s.assigned[name]; ok {
+		return v, true
+	}
+	return s.lookup(name)
+}
+
+// lookupParam returns the value of the named variable, recording
+// a reference to an unset variable when the NoUnset option is
+// enabled.
+func (s *state) lookupParam(name string) string {
+	v, ok := s.lookupVar(name)
+	if !ok && s.noUnset {
+		s.addUnset(name)
+	}
+	return v
+}
+
+// addUnset records a reference to an unset variable.
+func (s *state) addUnset(name string) {
+	for _, v := range s.unset {
+		if v == name {
+			return
+		}
+	}
+	s.unset = append(s.unset, name)
+}
+
+// isNull reports whether the variable is considered null. Functions
+// that include a colon treat a variable that is set to the empty
+// string as null.
+func isNull(colon bool, v string, ok bool) bool {
+	if !ok {
+		return true
 	}
+	return v == "" && colon
 }